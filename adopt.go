@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// createImageDescriptionPattern matches description of snapshots which are created by CreateImage.
+var createImageDescriptionPattern = regexp.MustCompile(`^Created by CreateImage\((i-[0-9a-f]+)\) for (ami-[0-9a-f]+)`)
+
+// backupSetIDPattern matches BackupSetId tag value of snapshot-only backups.
+var backupSetIDPattern = regexp.MustCompile(`^(i-[0-9a-f]+)-\d{14}$`)
+
+// Adoption backfills InstanceId tag of existing backups which were created without it.
+type Adoption struct {
+	Schema TagSchema
+	Client AWS
+}
+
+// Run tags machine images and snapshots which do not have InstanceId tag with the source instance id,
+// and returns ids of adopted resources and ids of machine images whose source instance is unknown.
+// The source instance is found by description of snapshots which are created by CreateImage,
+// and encrypted copies are adopted by the source image.
+func (a *Adoption) Run(ctx context.Context) ([]string, []string, error) {
+	var adoptedIDs, unknownIDs []string
+
+	backupType := []*ec2.Tag{a.Schema.Tag(TagBackupType, a.Schema.BackupType())}
+	instanceIDKey := a.Schema.Key(TagInstanceID)
+
+	images, err := a.Client.GetImages(ctx, backupType)
+	if err != nil {
+		return adoptedIDs, unknownIDs, err
+	}
+
+	snapshots, err := a.Client.GetTaggedSnapshots(ctx, backupType, nil)
+	if err != nil {
+		return adoptedIDs, unknownIDs, err
+	}
+	snapshotMap := make(map[string]*ec2.Snapshot)
+	for _, s := range snapshots {
+		snapshotMap[*s.SnapshotId] = s
+	}
+
+	imageInstanceIDs := make(map[string]string)
+	for _, image := range images {
+		if instanceID := tagValue(image.Tags, instanceIDKey); instanceID != "" {
+			imageInstanceIDs[*image.ImageId] = instanceID
+			continue
+		}
+		for _, snapshotID := range imageSnapshots(image) {
+			s, ok := snapshotMap[snapshotID]
+			if !ok {
+				continue
+			}
+			m := createImageDescriptionPattern.FindStringSubmatch(aws.StringValue(s.Description))
+			if m != nil && m[2] == *image.ImageId {
+				imageInstanceIDs[*image.ImageId] = m[1]
+				break
+			}
+		}
+	}
+
+	var adoptImages []*ec2.Image
+	for _, image := range images {
+		if hasTag(image.Tags, instanceIDKey) {
+			continue
+		}
+		if _, ok := imageInstanceIDs[*image.ImageId]; !ok {
+			source := tagValue(image.Tags, a.Schema.Key(TagSourceImageID))
+			instanceID, ok := imageInstanceIDs[source]
+			if !ok {
+				unknownIDs = append(unknownIDs, *image.ImageId)
+				continue
+			}
+			imageInstanceIDs[*image.ImageId] = instanceID
+		}
+		adoptImages = append(adoptImages, image)
+	}
+
+	for _, image := range adoptImages {
+		tag := []*ec2.Tag{a.Schema.Tag(TagInstanceID, imageInstanceIDs[*image.ImageId])}
+		if err := a.Client.CreateTags(ctx, *image.ImageId, tag); err != nil {
+			return adoptedIDs, unknownIDs, err
+		}
+		adoptedIDs = append(adoptedIDs, *image.ImageId)
+
+		for _, snapshotID := range imageSnapshots(image) {
+			s, ok := snapshotMap[snapshotID]
+			if !ok || hasTag(s.Tags, instanceIDKey) {
+				continue
+			}
+			if err := a.Client.CreateTags(ctx, snapshotID, tag); err != nil {
+				return adoptedIDs, unknownIDs, err
+			}
+			adoptedIDs = append(adoptedIDs, snapshotID)
+		}
+	}
+
+	// snapshots of snapshot-only backups have the instance id in BackupSetId tag
+	for _, s := range snapshots {
+		if hasTag(s.Tags, instanceIDKey) {
+			continue
+		}
+		m := backupSetIDPattern.FindStringSubmatch(tagValue(s.Tags, a.Schema.Key(TagBackupSetID)))
+		if m == nil {
+			continue
+		}
+		if err := a.Client.CreateTags(ctx, *s.SnapshotId, []*ec2.Tag{a.Schema.Tag(TagInstanceID, m[1])}); err != nil {
+			return adoptedIDs, unknownIDs, err
+		}
+		adoptedIDs = append(adoptedIDs, *s.SnapshotId)
+	}
+
+	return adoptedIDs, unknownIDs, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/heartbeatsjp/go-create-image-backup/mock"
+)
+
+func TestAdoptionRun(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	backupType := []*ec2.Tag{{Key: aws.String("BackupType"), Value: aws.String("auto")}}
+	instanceIDTag := []*ec2.Tag{{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")}}
+	mockAWSClient.EXPECT().GetImages(context.TODO(), backupType).Return([]*ec2.Image{
+		{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")}},
+			},
+			Tags: backupType,
+		},
+		{
+			ImageId: aws.String("ami-1234567890abcdef1"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef1")}},
+			},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("SourceImageId"), Value: aws.String("ami-1234567890abcdef0")},
+			},
+		},
+		{
+			ImageId: aws.String("ami-1234567890abcdef2"),
+			Tags:    backupType,
+		},
+		{
+			ImageId: aws.String("ami-1234567890abcdef3"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef1")},
+			},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), backupType, gomock.Nil()).Return([]*ec2.Snapshot{
+		{
+			SnapshotId:  aws.String("snap-1234567890abcdef0"),
+			Description: aws.String("Created by CreateImage(i-1234567890abcdef0) for ami-1234567890abcdef0"),
+			Tags:        backupType,
+		},
+		{
+			SnapshotId:  aws.String("snap-1234567890abcdef1"),
+			Description: aws.String("Copied for DestinationAmi ami-1234567890abcdef1 from SourceAmi ami-1234567890abcdef0"),
+			Tags:        backupType,
+		},
+		{
+			SnapshotId:  aws.String("snap-1234567890abcdef2"),
+			Description: aws.String("create by go-create-image-backup"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("BackupSetId"), Value: aws.String("i-1234567890abcdef0-20200102150405")},
+			},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "ami-1234567890abcdef0", instanceIDTag).Return(nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "snap-1234567890abcdef0", instanceIDTag).Return(nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "ami-1234567890abcdef1", instanceIDTag).Return(nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "snap-1234567890abcdef1", instanceIDTag).Return(nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "snap-1234567890abcdef2", instanceIDTag).Return(nil)
+
+	adoption := &Adoption{Client: mockAWSClient}
+
+	adoptedIDs, unknownIDs, err := adoption.Run(context.TODO())
+	if err != nil {
+		t.Fatal("Run failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0", "snap-1234567890abcdef0", "ami-1234567890abcdef1", "snap-1234567890abcdef1", "snap-1234567890abcdef2"}
+	if !reflect.DeepEqual(adoptedIDs, want) {
+		t.Fatalf("got %s, want %s", adoptedIDs, want)
+	}
+	if want := []string{"ami-1234567890abcdef2"}; !reflect.DeepEqual(unknownIDs, want) {
+		t.Fatalf("got %s, want %s", unknownIDs, want)
+	}
+}
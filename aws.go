@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -24,14 +25,39 @@ type EC2MetadataAPI interface {
 
 // AWS provides methods for AWS operations.
 type AWS interface {
+	GetRegion() (string, error)
 	GetInstanceID() (string, error)
 	GetInstanceName(ctx context.Context, instanceID string) (string, error)
-	CreateImage(ctx context.Context, instanceID, name, now string) (string, error)
+	GetInstanceTags(ctx context.Context, instanceID string) ([]*ec2.Tag, error)
+	GetInstanceIDs(ctx context.Context, filters []*ec2.Filter) ([]string, error)
+	GetInstanceVolumes(ctx context.Context, instanceID string) ([]*ec2.Volume, error)
+	CreateImage(ctx context.Context, instanceID, name, description string, excludeDevices []string) (string, error)
+	CopyImage(ctx context.Context, sourceRegion, sourceImageID, name, description, kmsKeyID string) (string, error)
 	CreateTags(ctx context.Context, resourceID string, tags []*ec2.Tag) error
-	GetImages(ctx context.Context, name, service string) ([]*ec2.Image, error)
+	DeleteTags(ctx context.Context, resourceID string, keys []string) error
+	GetImages(ctx context.Context, tags []*ec2.Tag) ([]*ec2.Image, error)
 	GetImage(ctx context.Context, imageID string) (*ec2.Image, error)
+	ImageNameExists(ctx context.Context, name string) (bool, error)
 	GetSnapshots(ctx context.Context, imageID string) ([]string, error)
+	CreateSnapshots(ctx context.Context, instanceID string, excludeVolumeIDs []string, tags []*ec2.Tag) ([]string, error)
+	GetTaggedSnapshots(ctx context.Context, tags []*ec2.Tag, tagKeys []string) ([]*ec2.Snapshot, error)
+	DeleteSnapshots(ctx context.Context, snapshotIDs []string) error
+	FindImages(ctx context.Context, imageIDs []string) ([]*ec2.Image, error)
+	FindSnapshots(ctx context.Context, snapshotIDs []string) ([]*ec2.Snapshot, error)
+	DeprecateImage(ctx context.Context, imageID string, deprecateAt time.Time) error
+	ShareImage(ctx context.Context, imageID string, principals []string) error
+	UnshareImage(ctx context.Context, image *ec2.Image) error
+	ArchiveImage(ctx context.Context, image *ec2.Image, tags []*ec2.Tag) error
 	DeregisterImages(ctx context.Context, images []*ec2.Image) error
+	DryRunCreateImage(ctx context.Context, instanceID, name, description string, excludeDevices []string) error
+	DryRunDeregisterImages(ctx context.Context, images []*ec2.Image) error
+	DryRunCreateSnapshots(ctx context.Context, instanceID string, excludeVolumeIDs []string, tags []*ec2.Tag) error
+	DryRunDeleteSnapshots(ctx context.Context, snapshotIDs []string) error
+	DryRunArchiveImage(ctx context.Context, image *ec2.Image, tags []*ec2.Tag) error
+	GetInstance(ctx context.Context, instanceID string) (*ec2.Instance, error)
+	RunInstance(ctx context.Context, input *ec2.RunInstancesInput) (string, error)
+	CreateVolume(ctx context.Context, snapshotID, availabilityZone, volumeType string, iops, throughput int64, tags []*ec2.Tag) (string, error)
+	AttachVolume(ctx context.Context, volumeID, instanceID, device string) error
 }
 
 // AWSClient implements AWS.
@@ -93,6 +119,14 @@ func NewAWSClient(sess *session.Session, region string) (*AWSClient, error) {
 	}, nil
 }
 
+// GetRegion returns region of the client.
+func (client *AWSClient) GetRegion() (string, error) {
+	if client.config == nil || aws.StringValue(client.config.Region) == "" {
+		return "", errors.New("region is not set")
+	}
+	return *client.config.Region, nil
+}
+
 // GetInstanceID returns instance id, this method available at AWS EC2 instance.
 func (client *AWSClient) GetInstanceID() (string, error) {
 	if client.svcEC2Metadata.Available() {
@@ -129,14 +163,215 @@ func (client *AWSClient) GetInstanceName(ctx context.Context, instanceID string)
 	return name, nil
 }
 
+// GetInstanceTags returns tags of instance which has instance id.
+func (client *AWSClient) GetInstanceTags(ctx context.Context, instanceID string) ([]*ec2.Tag, error) {
+	var tags []*ec2.Tag
+	err := client.svcEC2.DescribeTagsPagesWithContext(ctx, &ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("resource-id"), Values: []*string{aws.String(instanceID)}},
+		},
+	}, func(page *ec2.DescribeTagsOutput, lastPage bool) bool {
+		for _, t := range page.Tags {
+			tags = append(tags, &ec2.Tag{Key: t.Key, Value: t.Value})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetInstanceIDs returns ids of instances which match the specified filters.
+func (client *AWSClient) GetInstanceIDs(ctx context.Context, filters []*ec2.Filter) ([]string, error) {
+	var instanceIDs []string
+	err := client.svcEC2.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+		Filters: filters,
+	}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				instanceIDs = append(instanceIDs, *i.InstanceId)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return instanceIDs, nil
+}
+
+// GetInstance returns instance which has instance id, or nil when the instance does not exist.
+func (client *AWSClient) GetInstance(ctx context.Context, instanceID string) (*ec2.Instance, error) {
+	// instance-id filter does not fail when the instance does not exist unlike InstanceIds
+	result, err := client.svcEC2.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("instance-id"), Values: []*string{aws.String(instanceID)}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range result.Reservations {
+		for _, i := range r.Instances {
+			return i, nil
+		}
+	}
+	return nil, nil
+}
+
+// RunInstance launches an instance and returns the instance id, the count of input is always 1.
+func (client *AWSClient) RunInstance(ctx context.Context, input *ec2.RunInstancesInput) (string, error) {
+	input.MinCount = aws.Int64(1)
+	input.MaxCount = aws.Int64(1)
+
+	result, err := client.svcEC2.RunInstancesWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+
+	if len(result.Instances) < 1 {
+		return "", errors.New("no instance is launched")
+	}
+
+	return *result.Instances[0].InstanceId, nil
+}
+
+// CreateVolume creates an EBS volume from the snapshot, and waits until the volume is available.
+// The volume type is the default of EC2 when it is empty, and IOPS and throughput are the default of the volume type when these are zero.
+func (client *AWSClient) CreateVolume(ctx context.Context, snapshotID, availabilityZone, volumeType string, iops, throughput int64, tags []*ec2.Tag) (string, error) {
+	input := &ec2.CreateVolumeInput{
+		SnapshotId:       aws.String(snapshotID),
+		AvailabilityZone: aws.String(availabilityZone),
+	}
+	if volumeType != "" {
+		input.VolumeType = aws.String(volumeType)
+	}
+	if iops > 0 {
+		input.Iops = aws.Int64(iops)
+	}
+	if throughput > 0 {
+		input.Throughput = aws.Int64(throughput)
+	}
+	if len(tags) > 0 {
+		input.TagSpecifications = []*ec2.TagSpecification{{ResourceType: aws.String(ec2.ResourceTypeVolume), Tags: tags}}
+	}
+
+	result, err := client.svcEC2.CreateVolumeWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+
+	volumeID := *result.VolumeId
+
+	if err := client.svcEC2.WaitUntilVolumeAvailableWithContext(
+		ctx,
+		&ec2.DescribeVolumesInput{
+			VolumeIds: []*string{aws.String(volumeID)},
+		},
+		[]request.WaiterOption{request.WithWaiterMaxAttempts(120)}...,
+	); err != nil {
+		return "", err
+	}
+
+	return volumeID, nil
+}
+
+// AttachVolume attaches the EBS volume to the instance at the device, and waits until the volume is in use.
+func (client *AWSClient) AttachVolume(ctx context.Context, volumeID, instanceID, device string) error {
+	_, err := client.svcEC2.AttachVolumeWithContext(ctx, &ec2.AttachVolumeInput{
+		VolumeId:   aws.String(volumeID),
+		InstanceId: aws.String(instanceID),
+		Device:     aws.String(device),
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.svcEC2.WaitUntilVolumeInUseWithContext(
+		ctx,
+		&ec2.DescribeVolumesInput{
+			VolumeIds: []*string{aws.String(volumeID)},
+		},
+		[]request.WaiterOption{request.WithWaiterMaxAttempts(120)}...,
+	)
+}
+
+// GetInstanceVolumes returns EBS volumes attached to instance which has instance id.
+func (client *AWSClient) GetInstanceVolumes(ctx context.Context, instanceID string) ([]*ec2.Volume, error) {
+	var volumes []*ec2.Volume
+	err := client.svcEC2.DescribeVolumesPagesWithContext(ctx, &ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("attachment.instance-id"), Values: []*string{aws.String(instanceID)}},
+		},
+	}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		volumes = append(volumes, page.Volumes...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return volumes, nil
+}
+
 // CreateImage creates machine image for instance which has instance id.
-func (client *AWSClient) CreateImage(ctx context.Context, instanceID, name, now string) (string, error) {
-	result, err := client.svcEC2.CreateImageWithContext(ctx, &ec2.CreateImageInput{
+// The volumes attached at excludeDevices are not included in the machine image.
+func (client *AWSClient) CreateImage(ctx context.Context, instanceID, name, description string, excludeDevices []string) (string, error) {
+	result, err := client.svcEC2.CreateImageWithContext(ctx, createImageInput(instanceID, name, description, excludeDevices))
+	if err != nil {
+		return "", err
+	}
+
+	imageID := *result.ImageId
+
+	if err := client.svcEC2.WaitUntilImageAvailableWithContext(
+		ctx,
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String(imageID)},
+		},
+		[]request.WaiterOption{request.WithWaiterMaxAttempts(120)}...,
+	); err != nil {
+		return "", err
+	}
+
+	return imageID, nil
+}
+
+func createImageInput(instanceID, name, description string, excludeDevices []string) *ec2.CreateImageInput {
+	input := &ec2.CreateImageInput{
 		InstanceId:  aws.String(instanceID),
-		Description: aws.String("create by go-create-image-backup"),
-		Name:        aws.String(fmt.Sprintf("%s-%s", name, now)),
+		Description: aws.String(description),
+		Name:        aws.String(name),
 		NoReboot:    aws.Bool(true),
-	})
+	}
+	for _, d := range excludeDevices {
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, &ec2.BlockDeviceMapping{
+			DeviceName: aws.String(d),
+			NoDevice:   aws.String(""),
+		})
+	}
+	return input
+}
+
+// CopyImage copies machine image from the source region to the region of client.
+// The snapshots of copied image are encrypted by the KMS key when KMS key id is specified.
+func (client *AWSClient) CopyImage(ctx context.Context, sourceRegion, sourceImageID, name, description, kmsKeyID string) (string, error) {
+	input := &ec2.CopyImageInput{
+		SourceRegion:  aws.String(sourceRegion),
+		SourceImageId: aws.String(sourceImageID),
+		Name:          aws.String(name),
+		Description:   aws.String(description),
+	}
+	if kmsKeyID != "" {
+		input.Encrypted = aws.Bool(true)
+		input.KmsKeyId = aws.String(kmsKeyID)
+	}
+
+	result, err := client.svcEC2.CopyImageWithContext(ctx, input)
 	if err != nil {
 		return "", err
 	}
@@ -176,7 +411,7 @@ func (client *AWSClient) CreateTags(ctx context.Context, resourceID string, tags
 			if err != nil {
 				continue
 			}
-			if len(result.Images[0].Tags) == len(tags) {
+			if containsTags(result.Images[0].Tags, tags) {
 				completed = true
 				break
 			}
@@ -187,12 +422,16 @@ func (client *AWSClient) CreateTags(ctx context.Context, resourceID string, tags
 			if err != nil {
 				continue
 			}
-			if len(result.Snapshots[0].Tags) == len(tags) {
+			if containsTags(result.Snapshots[0].Tags, tags) {
 				completed = true
 				break
 			}
 		}
-		time.Sleep(time.Duration(i+1) * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(i+1) * time.Second):
+		}
 	}
 
 	if !completed {
@@ -202,14 +441,47 @@ func (client *AWSClient) CreateTags(ctx context.Context, resourceID string, tags
 	return nil
 }
 
+// containsTags returns whether tags of the resource have all keys and values of the tags,
+// the resource may have other tags which were created before.
+func containsTags(resourceTags, tags []*ec2.Tag) bool {
+	for _, t := range tags {
+		if !hasTag(resourceTags, aws.StringValue(t.Key)) || tagValue(resourceTags, aws.StringValue(t.Key)) != aws.StringValue(t.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteTags deletes tags which have the keys from the resource.
+func (client *AWSClient) DeleteTags(ctx context.Context, resourceID string, keys []string) error {
+	var tags []*ec2.Tag
+	for _, k := range keys {
+		tags = append(tags, &ec2.Tag{Key: aws.String(k)})
+	}
+
+	_, err := client.svcEC2.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
+		Resources: []*string{aws.String(resourceID)},
+		Tags:      tags,
+	})
+	return err
+}
+
+// tagFilters returns filters which match resources with all of the tags.
+func tagFilters(tags []*ec2.Tag) []*ec2.Filter {
+	var filters []*ec2.Filter
+	for _, t := range tags {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("tag:" + aws.StringValue(t.Key)),
+			Values: []*string{t.Value},
+		})
+	}
+	return filters
+}
+
 // GetImages return machine images with the specified tag values.
-func (client *AWSClient) GetImages(ctx context.Context, name, service string) ([]*ec2.Image, error) {
+func (client *AWSClient) GetImages(ctx context.Context, tags []*ec2.Tag) ([]*ec2.Image, error) {
 	result, err := client.svcEC2.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
-		Filters: []*ec2.Filter{
-			{Name: aws.String("tag:BackupType"), Values: []*string{aws.String("auto")}},
-			{Name: aws.String("tag:Name"), Values: []*string{aws.String(name)}},
-			{Name: aws.String("tag:Service"), Values: []*string{aws.String(service)}},
-		},
+		Filters: tagFilters(tags),
 	})
 	if err != nil {
 		return nil, err
@@ -234,6 +506,21 @@ func (client *AWSClient) GetImage(ctx context.Context, imageID string) (*ec2.Ima
 	return result.Images[0], nil
 }
 
+// ImageNameExists returns whether machine image which has the name exists.
+func (client *AWSClient) ImageNameExists(ctx context.Context, name string) (bool, error) {
+	result, err := client.svcEC2.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+		Owners: []*string{aws.String("self")},
+		Filters: []*ec2.Filter{
+			{Name: aws.String("name"), Values: []*string{aws.String(name)}},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(result.Images) > 0, nil
+}
+
 // GetSnapshots returns snapshot ids which machine image id related.
 func (client *AWSClient) GetSnapshots(ctx context.Context, imageID string) ([]string, error) {
 	result, err := client.svcEC2.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
@@ -243,31 +530,495 @@ func (client *AWSClient) GetSnapshots(ctx context.Context, imageID string) ([]st
 		return nil, err
 	}
 
+	if len(result.Images) < 1 {
+		return nil, fmt.Errorf("can't find image: %s", imageID)
+	}
+
+	return imageSnapshots(result.Images[0]), nil
+}
+
+// imageSnapshots returns snapshot ids of the machine image,
+// devices which are excluded from the machine image or not EBS are ignored.
+func imageSnapshots(image *ec2.Image) []string {
 	var snapshots []string
-	for _, b := range result.Images[0].BlockDeviceMappings {
-		if b.Ebs == nil {
+	for _, b := range image.BlockDeviceMappings {
+		if b.Ebs == nil || b.Ebs.SnapshotId == nil || b.NoDevice != nil {
 			continue
 		}
 		snapshots = append(snapshots, *b.Ebs.SnapshotId)
 	}
+	return snapshots
+}
+
+// CreateSnapshots creates crash-consistent snapshots of all EBS volumes attached to instance which has instance id.
+// The volumes of excludeVolumeIDs are not included, and snapshots are created with the tags.
+func (client *AWSClient) CreateSnapshots(ctx context.Context, instanceID string, excludeVolumeIDs []string, tags []*ec2.Tag) ([]string, error) {
+	result, err := client.svcEC2.CreateSnapshotsWithContext(ctx, createSnapshotsInput(instanceID, excludeVolumeIDs, tags))
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshotIDs []*string
+	for _, s := range result.Snapshots {
+		snapshotIDs = append(snapshotIDs, s.SnapshotId)
+	}
+
+	if err := client.svcEC2.WaitUntilSnapshotCompletedWithContext(
+		ctx,
+		&ec2.DescribeSnapshotsInput{
+			SnapshotIds: snapshotIDs,
+		},
+		[]request.WaiterOption{request.WithWaiterMaxAttempts(120)}...,
+	); err != nil {
+		return nil, err
+	}
+
+	return aws.StringValueSlice(snapshotIDs), nil
+}
+
+func createSnapshotsInput(instanceID string, excludeVolumeIDs []string, tags []*ec2.Tag) *ec2.CreateSnapshotsInput {
+	input := &ec2.CreateSnapshotsInput{
+		Description: aws.String("create by go-create-image-backup"),
+		InstanceSpecification: &ec2.InstanceSpecification{
+			InstanceId: aws.String(instanceID),
+		},
+		TagSpecifications: []*ec2.TagSpecification{
+			{ResourceType: aws.String(ec2.ResourceTypeSnapshot), Tags: tags},
+		},
+	}
+	for _, v := range excludeVolumeIDs {
+		input.InstanceSpecification.ExcludeDataVolumeIds = append(input.InstanceSpecification.ExcludeDataVolumeIds, aws.String(v))
+	}
+	return input
+}
+
+// GetTaggedSnapshots returns snapshots with the specified tag values and tag keys.
+func (client *AWSClient) GetTaggedSnapshots(ctx context.Context, tags []*ec2.Tag, tagKeys []string) ([]*ec2.Snapshot, error) {
+	filters := tagFilters(tags)
+	for _, k := range tagKeys {
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String(k)}})
+	}
 
+	var snapshots []*ec2.Snapshot
+	err := client.svcEC2.DescribeSnapshotsPagesWithContext(ctx, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+		Filters:  filters,
+	}, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		snapshots = append(snapshots, page.Snapshots...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+// maxFilterValues is the number of values of a filter in a request to describe resources.
+const maxFilterValues = 200
+
+// FindImages returns own machine images which have any of the image ids.
+// Unlike GetImage, image ids which do not exist are not errors and are just not returned.
+func (client *AWSClient) FindImages(ctx context.Context, imageIDs []string) ([]*ec2.Image, error) {
+	var images []*ec2.Image
+	for i := 0; i < len(imageIDs); i += maxFilterValues {
+		ids := imageIDs[i:]
+		if len(ids) > maxFilterValues {
+			ids = ids[:maxFilterValues]
+		}
+		result, err := client.svcEC2.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+			Owners:  []*string{aws.String("self")},
+			Filters: []*ec2.Filter{{Name: aws.String("image-id"), Values: aws.StringSlice(ids)}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, result.Images...)
+	}
+	return images, nil
+}
+
+// FindSnapshots returns own snapshots which have any of the snapshot ids.
+// Snapshot ids which do not exist are not errors and are just not returned.
+func (client *AWSClient) FindSnapshots(ctx context.Context, snapshotIDs []string) ([]*ec2.Snapshot, error) {
+	var snapshots []*ec2.Snapshot
+	for i := 0; i < len(snapshotIDs); i += maxFilterValues {
+		ids := snapshotIDs[i:]
+		if len(ids) > maxFilterValues {
+			ids = ids[:maxFilterValues]
+		}
+		err := client.svcEC2.DescribeSnapshotsPagesWithContext(ctx, &ec2.DescribeSnapshotsInput{
+			OwnerIds: []*string{aws.String("self")},
+			Filters:  []*ec2.Filter{{Name: aws.String("snapshot-id"), Values: aws.StringSlice(ids)}},
+		}, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+			snapshots = append(snapshots, page.Snapshots...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 	return snapshots, nil
 }
 
+// DeleteSnapshots deletes snapshots.
+// Transient errors are retried, and failures of each snapshot are returned as deleteSnapshotsError.
+func (client *AWSClient) DeleteSnapshots(ctx context.Context, snapshotIDs []string) error {
+	deleteErr := &deleteSnapshotsError{total: len(snapshotIDs)}
+	for _, snapshot := range snapshotIDs {
+		err := retry(ctx, isTransientError, func() error {
+			_, err := client.svcEC2.DeleteSnapshotWithContext(ctx, &ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String(snapshot),
+			})
+			return err
+		})
+		// the snapshot may be already deleted by the previous run which failed to delete the others
+		if err != nil && awsErrorCode(err) != "InvalidSnapshot.NotFound" {
+			deleteErr.failures = append(deleteErr.failures, resourceError{resourceID: snapshot, err: err})
+		}
+	}
+
+	if len(deleteErr.failures) > 0 {
+		return deleteErr
+	}
+	return nil
+}
+
+// DeprecateImage sets deprecation time of machine image.
+func (client *AWSClient) DeprecateImage(ctx context.Context, imageID string, deprecateAt time.Time) error {
+	_, err := client.svcEC2.EnableImageDeprecationWithContext(ctx, &ec2.EnableImageDeprecationInput{
+		ImageId:     aws.String(imageID),
+		DeprecateAt: aws.Time(deprecateAt),
+	})
+	return err
+}
+
+// ShareImage grants launch permission of machine image and create volume permission of related snapshots to principals.
+// The principals are AWS account ids, organization ARNs or organizational unit ARNs.
+// Snapshots are shared with AWS account ids only, because snapshots can not be shared with organizations.
+func (client *AWSClient) ShareImage(ctx context.Context, imageID string, principals []string) error {
+	var permissions []*ec2.LaunchPermission
+	var userIDs []*string
+	for _, p := range principals {
+		switch {
+		case strings.Contains(p, ":organization/"):
+			permissions = append(permissions, &ec2.LaunchPermission{OrganizationArn: aws.String(p)})
+		case strings.Contains(p, ":ou/"):
+			permissions = append(permissions, &ec2.LaunchPermission{OrganizationalUnitArn: aws.String(p)})
+		default:
+			permissions = append(permissions, &ec2.LaunchPermission{UserId: aws.String(p)})
+			userIDs = append(userIDs, aws.String(p))
+		}
+	}
+
+	_, err := client.svcEC2.ModifyImageAttributeWithContext(ctx, &ec2.ModifyImageAttributeInput{
+		ImageId:          aws.String(imageID),
+		LaunchPermission: &ec2.LaunchPermissionModifications{Add: permissions},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(userIDs) < 1 {
+		return nil
+	}
+
+	snapshots, err := client.GetSnapshots(ctx, imageID)
+	if err != nil {
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		_, err := client.svcEC2.ModifySnapshotAttributeWithContext(ctx, &ec2.ModifySnapshotAttributeInput{
+			SnapshotId:    aws.String(snapshot),
+			Attribute:     aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+			OperationType: aws.String(ec2.OperationTypeAdd),
+			UserIds:       userIDs,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnshareImage revokes all launch permissions of machine image and create volume permissions of related snapshots.
+// Permissions are revoked only when these are granted, so that machine images which are not shared are not modified.
+func (client *AWSClient) UnshareImage(ctx context.Context, image *ec2.Image) error {
+	imageAttr, err := client.svcEC2.DescribeImageAttributeWithContext(ctx, &ec2.DescribeImageAttributeInput{
+		ImageId:   image.ImageId,
+		Attribute: aws.String(ec2.ImageAttributeNameLaunchPermission),
+	})
+	if err != nil {
+		return err
+	}
+	if len(imageAttr.LaunchPermissions) > 0 {
+		_, err := client.svcEC2.ResetImageAttributeWithContext(ctx, &ec2.ResetImageAttributeInput{
+			ImageId:   image.ImageId,
+			Attribute: aws.String(ec2.ResetImageAttributeNameLaunchPermission),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, snapshot := range imageSnapshots(image) {
+		snapshotAttr, err := client.svcEC2.DescribeSnapshotAttributeWithContext(ctx, &ec2.DescribeSnapshotAttributeInput{
+			SnapshotId: aws.String(snapshot),
+			Attribute:  aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+		})
+		if err != nil {
+			return err
+		}
+		if len(snapshotAttr.CreateVolumePermissions) < 1 {
+			continue
+		}
+		_, err = client.svcEC2.ResetSnapshotAttributeWithContext(ctx, &ec2.ResetSnapshotAttributeInput{
+			SnapshotId: aws.String(snapshot),
+			Attribute:  aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ArchiveImage moves snapshots of machine image to the archive tier,
+// and tags machine image and snapshots for tracking archived backups.
+func (client *AWSClient) ArchiveImage(ctx context.Context, image *ec2.Image, tags []*ec2.Tag) error {
+	snapshots := imageSnapshots(image)
+	for _, snapshot := range snapshots {
+		_, err := client.svcEC2.ModifySnapshotTierWithContext(ctx, &ec2.ModifySnapshotTierInput{
+			SnapshotId:  aws.String(snapshot),
+			StorageTier: aws.String(ec2.TargetStorageTierArchive),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, resourceID := range append([]string{*image.ImageId}, snapshots...) {
+		if err := client.CreateTags(ctx, resourceID, tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DeregisterImages deregister machine images and related snapshots.
+// Transient errors are retried, and failures of each resource are returned as deregisterError.
+// Snapshots of a machine image which failed to deregister are not deleted, because these are still in use.
 func (client *AWSClient) DeregisterImages(ctx context.Context, images []*ec2.Image) error {
+	deregisterErr := &deregisterError{}
 	for _, image := range images {
-		client.svcEC2.DeregisterImageWithContext(ctx, &ec2.DeregisterImageInput{
-			ImageId: image.ImageId,
+		deregisterErr.total++
+		err := retry(ctx, isTransientError, func() error {
+			_, err := client.svcEC2.DeregisterImageWithContext(ctx, &ec2.DeregisterImageInput{
+				ImageId: image.ImageId,
+			})
+			return err
 		})
-		for _, d := range image.BlockDeviceMappings {
-			if d.Ebs == nil {
-				continue
+		// the machine image may be already deregistered by the previous rotation which failed to delete snapshots
+		if err != nil && awsErrorCode(err) != "InvalidAMIID.NotFound" {
+			deregisterErr.failures = append(deregisterErr.failures, resourceError{resourceID: *image.ImageId, err: err})
+			continue
+		}
+
+		for _, snapshot := range imageSnapshots(image) {
+			deregisterErr.total++
+			err := retry(ctx, isSnapshotInUseError, func() error {
+				_, err := client.svcEC2.DeleteSnapshotWithContext(ctx, &ec2.DeleteSnapshotInput{
+					SnapshotId: aws.String(snapshot),
+				})
+				return err
+			})
+			if err != nil && awsErrorCode(err) != "InvalidSnapshot.NotFound" {
+				deregisterErr.failures = append(deregisterErr.failures, resourceError{resourceID: snapshot, err: err})
 			}
-			client.svcEC2.DeleteSnapshot(&ec2.DeleteSnapshotInput{
-				SnapshotId: d.Ebs.SnapshotId,
+		}
+	}
+
+	if len(deregisterErr.failures) > 0 {
+		return deregisterErr
+	}
+	return nil
+}
+
+// resourceError is an error of an operation for a resource.
+type resourceError struct {
+	resourceID string
+	err        error
+}
+
+// deregisterError is an aggregated error of DeregisterImages for machine images and snapshots.
+type deregisterError struct {
+	failures []resourceError
+	total    int
+}
+
+func (e *deregisterError) Error() string {
+	var errList []string
+	for _, f := range e.failures {
+		errList = append(errList, fmt.Sprintf("%s: %s", f.resourceID, f.err.Error()))
+	}
+	return fmt.Sprintf("failed to deregister %d of %d images and snapshots: %s", len(e.failures), e.total, strings.Join(errList, ", "))
+}
+
+// failed returns whether the operation for the resource failed.
+func (e *deregisterError) failed(resourceID string) bool {
+	for _, f := range e.failures {
+		if f.resourceID == resourceID {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteSnapshotsError is an aggregated error of DeleteSnapshots for snapshots.
+type deleteSnapshotsError struct {
+	failures []resourceError
+	total    int
+}
+
+func (e *deleteSnapshotsError) Error() string {
+	var errList []string
+	for _, f := range e.failures {
+		errList = append(errList, fmt.Sprintf("%s: %s", f.resourceID, f.err.Error()))
+	}
+	return fmt.Sprintf("failed to delete %d of %d snapshots: %s", len(e.failures), e.total, strings.Join(errList, ", "))
+}
+
+// failed returns whether the deletion of the snapshot failed.
+func (e *deleteSnapshotsError) failed(snapshotID string) bool {
+	for _, f := range e.failures {
+		if f.resourceID == snapshotID {
+			return true
+		}
+	}
+	return false
+}
+
+// maxAttempts is the number of attempts of an operation which fails by transient errors.
+const maxAttempts = 5
+
+// retry calls fn until it succeeds or fails by an error which is not retryable, at most maxAttempts times.
+func retry(ctx context.Context, retryable func(error) bool, fn func() error) error {
+	var err error
+	for i := 0; i < maxAttempts; i++ {
+		if err = fn(); err == nil || !retryable(err) || i == maxAttempts-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(i+1) * time.Second):
+		}
+	}
+	return err
+}
+
+// isTransientError returns whether the error is throttling or a temporary error of the service.
+func isTransientError(err error) bool {
+	return request.IsErrorThrottle(err) || request.IsErrorRetryable(err)
+}
+
+// isSnapshotInUseError returns whether the error is transient, or the snapshot is still in use
+// by the machine image which was deregistered just before.
+func isSnapshotInUseError(err error) bool {
+	return isTransientError(err) || awsErrorCode(err) == "InvalidSnapshot.InUse"
+}
+
+func awsErrorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+// DryRunCreateImage verifies permissions to create machine image for instance without creating it.
+func (client *AWSClient) DryRunCreateImage(ctx context.Context, instanceID, name, description string, excludeDevices []string) error {
+	input := createImageInput(instanceID, name, description, excludeDevices)
+	input.DryRun = aws.Bool(true)
+	_, err := client.svcEC2.CreateImageWithContext(ctx, input)
+	return dryRunError(err)
+}
+
+// DryRunDeregisterImages verifies permissions to deregister machine images and delete related snapshots
+// without deregistering and deleting these.
+func (client *AWSClient) DryRunDeregisterImages(ctx context.Context, images []*ec2.Image) error {
+	for _, image := range images {
+		_, err := client.svcEC2.DeregisterImageWithContext(ctx, &ec2.DeregisterImageInput{
+			ImageId: image.ImageId,
+			DryRun:  aws.Bool(true),
+		})
+		if err := dryRunError(err); err != nil {
+			return err
+		}
+		for _, snapshot := range imageSnapshots(image) {
+			_, err := client.svcEC2.DeleteSnapshotWithContext(ctx, &ec2.DeleteSnapshotInput{
+				SnapshotId: aws.String(snapshot),
+				DryRun:     aws.Bool(true),
 			})
+			if err := dryRunError(err); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
+
+// DryRunCreateSnapshots verifies permissions to create snapshots of the instance without creating these.
+func (client *AWSClient) DryRunCreateSnapshots(ctx context.Context, instanceID string, excludeVolumeIDs []string, tags []*ec2.Tag) error {
+	input := createSnapshotsInput(instanceID, excludeVolumeIDs, tags)
+	input.DryRun = aws.Bool(true)
+	_, err := client.svcEC2.CreateSnapshotsWithContext(ctx, input)
+	return dryRunError(err)
+}
+
+// DryRunDeleteSnapshots verifies permissions to delete snapshots without deleting these.
+func (client *AWSClient) DryRunDeleteSnapshots(ctx context.Context, snapshotIDs []string) error {
+	for _, snapshot := range snapshotIDs {
+		_, err := client.svcEC2.DeleteSnapshotWithContext(ctx, &ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String(snapshot),
+			DryRun:     aws.Bool(true),
+		})
+		if err := dryRunError(err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DryRunArchiveImage verifies permissions to move snapshots of the machine image to the archive tier
+// and tag these without archiving and tagging these.
+func (client *AWSClient) DryRunArchiveImage(ctx context.Context, image *ec2.Image, tags []*ec2.Tag) error {
+	snapshots := imageSnapshots(image)
+	for _, snapshot := range snapshots {
+		_, err := client.svcEC2.ModifySnapshotTierWithContext(ctx, &ec2.ModifySnapshotTierInput{
+			SnapshotId:  aws.String(snapshot),
+			StorageTier: aws.String(ec2.TargetStorageTierArchive),
+			DryRun:      aws.Bool(true),
+		})
+		if err := dryRunError(err); err != nil {
+			return err
+		}
+	}
+
+	_, err := client.svcEC2.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: append([]*string{image.ImageId}, aws.StringSlice(snapshots)...),
+		Tags:      tags,
+		DryRun:    aws.Bool(true),
+	})
+	return dryRunError(err)
+}
+
+// dryRunError returns nil when the error of dry run means that the request would have succeeded.
+func dryRunError(err error) error {
+	if awsErrorCode(err) == "DryRunOperation" {
+		return nil
+	}
+	return err
+}
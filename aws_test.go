@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/mock/gomock"
@@ -45,6 +48,22 @@ func TestGetRegion_EC2Metadata_Unavailable(t *testing.T) {
 	}
 }
 
+func TestAWSClientGetRegion(t *testing.T) {
+	client := AWSClient{
+		config: aws.NewConfig().WithRegion("us-west-2"),
+	}
+
+	got, err := client.GetRegion()
+	if err != nil {
+		t.Fatal("GetRegion failed: ", err)
+	}
+
+	want := "us-west-2"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
 func TestGetInstanceID(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -151,6 +170,128 @@ func TestGetInstanceName_Tag_NotFound(t *testing.T) {
 	}
 }
 
+func TestGetInstanceTags(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeTagsPagesWithContext(
+		context.TODO(),
+		&ec2.DescribeTagsInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("resource-id"), Values: []*string{aws.String("i-1234567890abcdef0")}},
+			},
+		},
+		gomock.Any(),
+	).Do(func(ctx aws.Context, input *ec2.DescribeTagsInput, fn func(*ec2.DescribeTagsOutput, bool) bool) {
+		fn(&ec2.DescribeTagsOutput{
+			Tags: []*ec2.TagDescription{
+				{ResourceId: aws.String("i-1234567890abcdef0"), Key: aws.String("Name"), Value: aws.String("test")},
+				{ResourceId: aws.String("i-1234567890abcdef0"), Key: aws.String("CostCenter"), Value: aws.String("1234")},
+			},
+		}, true)
+	}).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.GetInstanceTags(context.TODO(), "i-1234567890abcdef0")
+	if err != nil {
+		t.Fatal("GetInstanceTags failed: ", err)
+	}
+
+	want := []*ec2.Tag{
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("CostCenter"), Value: aws.String("1234")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestGetInstanceIDs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	filters := []*ec2.Filter{
+		{Name: aws.String("tag:Env"), Values: []*string{aws.String("prod")}},
+	}
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeInstancesPagesWithContext(
+		context.TODO(),
+		&ec2.DescribeInstancesInput{Filters: filters},
+		gomock.Any(),
+	).Do(func(ctx aws.Context, input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) {
+		fn(&ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{
+				{Instances: []*ec2.Instance{
+					{InstanceId: aws.String("i-1234567890abcdef0")},
+					{InstanceId: aws.String("i-1234567890abcdef1")},
+				}},
+			},
+		}, false)
+		fn(&ec2.DescribeInstancesOutput{
+			Reservations: []*ec2.Reservation{
+				{Instances: []*ec2.Instance{
+					{InstanceId: aws.String("i-1234567890abcdef2")},
+				}},
+			},
+		}, true)
+	}).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.GetInstanceIDs(context.TODO(), filters)
+	if err != nil {
+		t.Fatal("GetInstanceIDs failed: ", err)
+	}
+
+	want := []string{"i-1234567890abcdef0", "i-1234567890abcdef1", "i-1234567890abcdef2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestGetInstanceVolumes(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeVolumesPagesWithContext(
+		context.TODO(),
+		&ec2.DescribeVolumesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("attachment.instance-id"), Values: []*string{aws.String("i-1234567890abcdef0")}},
+			},
+		},
+		gomock.Any(),
+	).Do(func(ctx aws.Context, input *ec2.DescribeVolumesInput, fn func(*ec2.DescribeVolumesOutput, bool) bool) {
+		fn(&ec2.DescribeVolumesOutput{
+			Volumes: []*ec2.Volume{
+				{VolumeId: aws.String("vol-1234567890abcdef0")},
+				{VolumeId: aws.String("vol-1234567890abcdef1")},
+			},
+		}, true)
+	}).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0")
+	if err != nil {
+		t.Fatal("GetInstanceVolumes failed: ", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d, want %d", len(got), 2)
+	}
+}
+
 func TestCreateImage(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -178,7 +319,126 @@ func TestCreateImage(t *testing.T) {
 		svcEC2: mockEC2,
 	}
 
-	got, err := client.CreateImage(context.TODO(), "i-1234567890abcdef0", "test", "200601021504")
+	got, err := client.CreateImage(context.TODO(), "i-1234567890abcdef0", "test-200601021504", "create by go-create-image-backup", nil)
+	if err != nil {
+		t.Fatal("CreateImage failed: ", err)
+	}
+
+	want := "ami-1234567890abcdef0"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCopyImage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().CopyImageWithContext(
+		context.TODO(),
+		&ec2.CopyImageInput{
+			SourceRegion:  aws.String("ap-northeast-1"),
+			SourceImageId: aws.String("ami-1234567890abcdef0"),
+			Name:          aws.String("test-200601021504"),
+			Description:   aws.String("create by go-create-image-backup"),
+		}).Return(&ec2.CopyImageOutput{
+		ImageId: aws.String("ami-0987654321abcdef0"),
+	}, nil)
+	mockEC2.EXPECT().WaitUntilImageAvailableWithContext(
+		context.TODO(),
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-0987654321abcdef0")},
+		},
+		gomock.Any(),
+	).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.CopyImage(context.TODO(), "ap-northeast-1", "ami-1234567890abcdef0", "test-200601021504", "create by go-create-image-backup", "")
+	if err != nil {
+		t.Fatal("CopyImage failed: ", err)
+	}
+
+	want := "ami-0987654321abcdef0"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCopyImage_Encrypted(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().CopyImageWithContext(
+		context.TODO(),
+		&ec2.CopyImageInput{
+			SourceRegion:  aws.String("ap-northeast-1"),
+			SourceImageId: aws.String("ami-1234567890abcdef0"),
+			Name:          aws.String("test-200601021504-encrypted"),
+			Description:   aws.String("create by go-create-image-backup"),
+			Encrypted:     aws.Bool(true),
+			KmsKeyId:      aws.String("alias/backup"),
+		}).Return(&ec2.CopyImageOutput{
+		ImageId: aws.String("ami-0987654321abcdef0"),
+	}, nil)
+	mockEC2.EXPECT().WaitUntilImageAvailableWithContext(
+		context.TODO(),
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-0987654321abcdef0")},
+		},
+		gomock.Any(),
+	).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.CopyImage(context.TODO(), "ap-northeast-1", "ami-1234567890abcdef0", "test-200601021504-encrypted", "create by go-create-image-backup", "alias/backup")
+	if err != nil {
+		t.Fatal("CopyImage failed: ", err)
+	}
+
+	want := "ami-0987654321abcdef0"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCreateImage_ExcludeDevices(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().CreateImageWithContext(
+		context.TODO(),
+		&ec2.CreateImageInput{
+			InstanceId:  aws.String("i-1234567890abcdef0"),
+			Description: aws.String("create by go-create-image-backup"),
+			Name:        aws.String("test-200601021504"),
+			NoReboot:    aws.Bool(true),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/sdf"), NoDevice: aws.String("")},
+			},
+		}).Return(&ec2.CreateImageOutput{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+	}, nil)
+	mockEC2.EXPECT().WaitUntilImageAvailableWithContext(
+		context.TODO(),
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-1234567890abcdef0")},
+		},
+		gomock.Any(),
+	).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.CreateImage(context.TODO(), "i-1234567890abcdef0", "test-200601021504", "create by go-create-image-backup", []string{"/dev/sdf"})
 	if err != nil {
 		t.Fatal("CreateImage failed: ", err)
 	}
@@ -276,6 +536,102 @@ func TestCreateTags_With_Snapshot(t *testing.T) {
 	}
 }
 
+func TestCreateTags_With_ExistingTags(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tags := []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("")},
+	}
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().CreateTagsWithContext(
+		context.TODO(),
+		&ec2.CreateTagsInput{
+			Resources: []*string{aws.String("ami-1234567890abcdef0")},
+			Tags:      tags,
+		}).Return(nil, nil)
+	// old tags are still on the resource, and the value of Name is not updated yet at first
+	mockEC2.EXPECT().DescribeImages(
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-1234567890abcdef0")},
+		}).Return(&ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{
+			{
+				Tags: []*ec2.Tag{
+					{Key: aws.String("backup:BackupType"), Value: aws.String("auto")},
+					{Key: aws.String("BackupType"), Value: aws.String("auto")},
+					{Key: aws.String("Name"), Value: aws.String("old")},
+					{Key: aws.String("Service"), Value: aws.String("")},
+				},
+			},
+		},
+	}, nil)
+	mockEC2.EXPECT().DescribeImages(
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-1234567890abcdef0")},
+		}).Return(&ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{
+			{
+				Tags: []*ec2.Tag{
+					{Key: aws.String("backup:BackupType"), Value: aws.String("auto")},
+					{Key: aws.String("BackupType"), Value: aws.String("auto")},
+					{Key: aws.String("Name"), Value: aws.String("test")},
+					{Key: aws.String("Service"), Value: aws.String("")},
+					{Key: aws.String("Env"), Value: aws.String("prod")},
+				},
+			},
+		},
+	}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	if err := client.CreateTags(context.TODO(), "ami-1234567890abcdef0", tags); err != nil {
+		t.Fatal("CreateTags failed: ", err)
+	}
+}
+
+func TestCreateTags_With_SnapshotExistingTags(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tags := []*ec2.Tag{{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")}}
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().CreateTagsWithContext(
+		context.TODO(),
+		&ec2.CreateTagsInput{
+			Resources: []*string{aws.String("snap-1234567890abcdef0")},
+			Tags:      tags,
+		}).Return(nil, nil)
+	mockEC2.EXPECT().DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{aws.String("snap-1234567890abcdef0")},
+	}).Return(&ec2.DescribeSnapshotsOutput{
+		Snapshots: []*ec2.Snapshot{
+			{
+				Tags: []*ec2.Tag{
+					{Key: aws.String("BackupType"), Value: aws.String("auto")},
+					{Key: aws.String("Name"), Value: aws.String("test")},
+					{Key: aws.String("Service"), Value: aws.String("")},
+					{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+				},
+			},
+		},
+	}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	if err := client.CreateTags(context.TODO(), "snap-1234567890abcdef0", tags); err != nil {
+		t.Fatal("CreateTags failed: ", err)
+	}
+}
+
 func TestCreateTags_notCompleted(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -336,7 +692,11 @@ func TestGetImages(t *testing.T) {
 		svcEC2: mockEC2,
 	}
 
-	_, err := client.GetImages(context.TODO(), "test", "service")
+	_, err := client.GetImages(context.TODO(), []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+	})
 	if err != nil {
 		t.Fatal("GetImages failed: ", err)
 	}
@@ -392,7 +752,7 @@ func TestGetImage_Image_NotFound(t *testing.T) {
 	}
 }
 
-func TestGetSnapshots(t *testing.T) {
+func TestImageNameExists(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
@@ -400,38 +760,637 @@ func TestGetSnapshots(t *testing.T) {
 	mockEC2.EXPECT().DescribeImagesWithContext(
 		context.TODO(),
 		&ec2.DescribeImagesInput{
-			ImageIds: []*string{aws.String("ami-1234567890abcdef0")},
-		}).Return(&ec2.DescribeImagesOutput{
-		Images: []*ec2.Image{
-			{
-				BlockDeviceMappings: []*ec2.BlockDeviceMapping{
-					{
-						Ebs: &ec2.EbsBlockDevice{
-							SnapshotId: aws.String("snap-1234567890abcdef0"),
-						},
-					},
-				},
+			Owners: []*string{aws.String("self")},
+			Filters: []*ec2.Filter{
+				{Name: aws.String("name"), Values: []*string{aws.String("test-200601021504")}},
 			},
-		},
+		}).Return(&ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{{ImageId: aws.String("ami-1234567890abcdef0")}},
 	}, nil)
 
 	client := AWSClient{
 		svcEC2: mockEC2,
 	}
 
-	s, err := client.GetSnapshots(context.TODO(), "ami-1234567890abcdef0")
+	got, err := client.ImageNameExists(context.TODO(), "test-200601021504")
 	if err != nil {
-		t.Fatal("GetSnapshots: ", err)
+		t.Fatal("ImageNameExists failed: ", err)
 	}
 
-	got := len(s)
-	want := 1
+	if !got {
+		t.Fatalf("got %t, want %t", got, true)
+	}
+}
+
+func TestGetSnapshots(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeImagesWithContext(
+		context.TODO(),
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-1234567890abcdef0")},
+		}).Return(&ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{
+			{
+				BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+					{
+						Ebs: &ec2.EbsBlockDevice{
+							SnapshotId: aws.String("snap-1234567890abcdef0"),
+						},
+					},
+				},
+			},
+		},
+	}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	s, err := client.GetSnapshots(context.TODO(), "ami-1234567890abcdef0")
+	if err != nil {
+		t.Fatal("GetSnapshots: ", err)
+	}
+
+	got := len(s)
+	want := 1
 	if got != want {
 		t.Fatalf("got %d, want %d", got, want)
 	}
 }
 
-func TestGetSnapshots_Multi(t *testing.T) {
+func TestGetSnapshots_Multi(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeImagesWithContext(
+		context.TODO(),
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-1234567890abcdef0")},
+		}).Return(&ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{
+			{
+				BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+					{
+						Ebs: &ec2.EbsBlockDevice{
+							SnapshotId: aws.String("snap-1234567890abcdef0"),
+						},
+					},
+					{
+						Ebs: &ec2.EbsBlockDevice{
+							SnapshotId: aws.String("snap-1234567890abcdef1"),
+						},
+					},
+				},
+			},
+		},
+	}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	s, err := client.GetSnapshots(context.TODO(), "ami-1234567890abcdef0")
+	if err != nil {
+		t.Fatal("GetSnapshots: ", err)
+	}
+
+	got := len(s)
+	want := 2
+	if got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+}
+
+func TestCreateSnapshots(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tag := []*ec2.Tag{
+		{Key: aws.String("BackupSetId"), Value: aws.String("i-1234567890abcdef0-20060102150405")},
+	}
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().CreateSnapshotsWithContext(
+		context.TODO(),
+		&ec2.CreateSnapshotsInput{
+			Description: aws.String("create by go-create-image-backup"),
+			InstanceSpecification: &ec2.InstanceSpecification{
+				InstanceId:           aws.String("i-1234567890abcdef0"),
+				ExcludeDataVolumeIds: []*string{aws.String("vol-1234567890abcdef1")},
+			},
+			TagSpecifications: []*ec2.TagSpecification{
+				{ResourceType: aws.String("snapshot"), Tags: tag},
+			},
+		}).Return(&ec2.CreateSnapshotsOutput{
+		Snapshots: []*ec2.SnapshotInfo{
+			{SnapshotId: aws.String("snap-1234567890abcdef0")},
+		},
+	}, nil)
+	mockEC2.EXPECT().WaitUntilSnapshotCompletedWithContext(
+		context.TODO(),
+		&ec2.DescribeSnapshotsInput{
+			SnapshotIds: []*string{aws.String("snap-1234567890abcdef0")},
+		},
+		gomock.Any(),
+	).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.CreateSnapshots(context.TODO(), "i-1234567890abcdef0", []string{"vol-1234567890abcdef1"}, tag)
+	if err != nil {
+		t.Fatal("CreateSnapshots failed: ", err)
+	}
+
+	want := []string{"snap-1234567890abcdef0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestGetTaggedSnapshots(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeSnapshotsPagesWithContext(
+		context.TODO(),
+		&ec2.DescribeSnapshotsInput{
+			OwnerIds: []*string{aws.String("self")},
+			Filters: []*ec2.Filter{
+				{Name: aws.String("tag:BackupType"), Values: []*string{aws.String("auto")}},
+				{Name: aws.String("tag:Name"), Values: []*string{aws.String("test")}},
+				{Name: aws.String("tag:Service"), Values: []*string{aws.String("service")}},
+				{Name: aws.String("tag-key"), Values: []*string{aws.String("BackupSetId")}},
+			},
+		},
+		gomock.Any(),
+	).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	tags := []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+	}
+	if _, err := client.GetTaggedSnapshots(context.TODO(), tags, []string{"BackupSetId"}); err != nil {
+		t.Fatal("GetTaggedSnapshots failed: ", err)
+	}
+}
+
+func TestShareImage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().ModifyImageAttributeWithContext(
+		context.TODO(),
+		&ec2.ModifyImageAttributeInput{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+			LaunchPermission: &ec2.LaunchPermissionModifications{
+				Add: []*ec2.LaunchPermission{
+					{UserId: aws.String("123456789012")},
+					{OrganizationArn: aws.String("arn:aws:organizations::123456789012:organization/o-abcdefghij")},
+					{OrganizationalUnitArn: aws.String("arn:aws:organizations::123456789012:ou/o-abcdefghij/ou-abcd-efghijkl")},
+				},
+			},
+		}).Return(&ec2.ModifyImageAttributeOutput{}, nil)
+	mockEC2.EXPECT().DescribeImagesWithContext(
+		context.TODO(),
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-1234567890abcdef0")},
+		}).Return(&ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{
+			{
+				BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+					{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")}},
+				},
+			},
+		},
+	}, nil)
+	mockEC2.EXPECT().ModifySnapshotAttributeWithContext(
+		context.TODO(),
+		&ec2.ModifySnapshotAttributeInput{
+			SnapshotId:    aws.String("snap-1234567890abcdef0"),
+			Attribute:     aws.String("createVolumePermission"),
+			OperationType: aws.String("add"),
+			UserIds:       []*string{aws.String("123456789012")},
+		}).Return(&ec2.ModifySnapshotAttributeOutput{}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	principals := []string{
+		"123456789012",
+		"arn:aws:organizations::123456789012:organization/o-abcdefghij",
+		"arn:aws:organizations::123456789012:ou/o-abcdefghij/ou-abcd-efghijkl",
+	}
+	if err := client.ShareImage(context.TODO(), "ami-1234567890abcdef0", principals); err != nil {
+		t.Fatal("ShareImage failed: ", err)
+	}
+}
+
+func TestUnshareImage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeImageAttributeWithContext(
+		context.TODO(),
+		&ec2.DescribeImageAttributeInput{
+			ImageId:   aws.String("ami-1234567890abcdef0"),
+			Attribute: aws.String("launchPermission"),
+		}).Return(&ec2.DescribeImageAttributeOutput{
+		LaunchPermissions: []*ec2.LaunchPermission{{UserId: aws.String("123456789012")}},
+	}, nil)
+	mockEC2.EXPECT().ResetImageAttributeWithContext(
+		context.TODO(),
+		&ec2.ResetImageAttributeInput{
+			ImageId:   aws.String("ami-1234567890abcdef0"),
+			Attribute: aws.String("launchPermission"),
+		}).Return(&ec2.ResetImageAttributeOutput{}, nil)
+	mockEC2.EXPECT().DescribeSnapshotAttributeWithContext(
+		context.TODO(),
+		&ec2.DescribeSnapshotAttributeInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+			Attribute:  aws.String("createVolumePermission"),
+		}).Return(&ec2.DescribeSnapshotAttributeOutput{
+		CreateVolumePermissions: []*ec2.CreateVolumePermission{{UserId: aws.String("123456789012")}},
+	}, nil)
+	mockEC2.EXPECT().ResetSnapshotAttributeWithContext(
+		context.TODO(),
+		&ec2.ResetSnapshotAttributeInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+			Attribute:  aws.String("createVolumePermission"),
+		}).Return(&ec2.ResetSnapshotAttributeOutput{}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	i := &ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/sda"),
+				Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")},
+			},
+			{
+				DeviceName: aws.String("/dev/ephemeral0"),
+			},
+		},
+	}
+	if err := client.UnshareImage(context.TODO(), i); err != nil {
+		t.Fatal("UnshareImage failed: ", err)
+	}
+}
+
+func TestUnshareImage_NotShared(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// permissions are not reset when these are not granted
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeImageAttributeWithContext(context.TODO(), gomock.Any()).Return(&ec2.DescribeImageAttributeOutput{}, nil)
+	mockEC2.EXPECT().DescribeSnapshotAttributeWithContext(context.TODO(), gomock.Any()).Return(&ec2.DescribeSnapshotAttributeOutput{}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	i := &ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/sda"),
+				Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")},
+			},
+		},
+	}
+	if err := client.UnshareImage(context.TODO(), i); err != nil {
+		t.Fatal("UnshareImage failed: ", err)
+	}
+}
+
+func TestArchiveImage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().ModifySnapshotTierWithContext(
+		context.TODO(),
+		&ec2.ModifySnapshotTierInput{
+			SnapshotId:  aws.String("snap-1234567890abcdef0"),
+			StorageTier: aws.String("archive"),
+		}).Return(&ec2.ModifySnapshotTierOutput{}, nil)
+	mockEC2.EXPECT().CreateTagsWithContext(
+		context.TODO(),
+		&ec2.CreateTagsInput{
+			Resources: []*string{aws.String("ami-1234567890abcdef0")},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupTier"), Value: aws.String("archive")},
+			},
+		}).Return(&ec2.CreateTagsOutput{}, nil)
+	mockEC2.EXPECT().DescribeImages(
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String("ami-1234567890abcdef0")},
+		}).Return(&ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{
+			{
+				Tags: []*ec2.Tag{
+					{Key: aws.String("BackupTier"), Value: aws.String("archive")},
+				},
+			},
+		},
+	}, nil)
+	mockEC2.EXPECT().CreateTagsWithContext(
+		context.TODO(),
+		&ec2.CreateTagsInput{
+			Resources: []*string{aws.String("snap-1234567890abcdef0")},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupTier"), Value: aws.String("archive")},
+			},
+		}).Return(&ec2.CreateTagsOutput{}, nil)
+	mockEC2.EXPECT().DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{aws.String("snap-1234567890abcdef0")},
+	}).Return(&ec2.DescribeSnapshotsOutput{
+		Snapshots: []*ec2.Snapshot{
+			{
+				Tags: []*ec2.Tag{
+					{Key: aws.String("BackupTier"), Value: aws.String("archive")},
+				},
+			},
+		},
+	}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	i := &ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/sda"),
+				Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")},
+			},
+		},
+	}
+	tags := []*ec2.Tag{
+		{Key: aws.String("BackupTier"), Value: aws.String("archive")},
+	}
+	if err := client.ArchiveImage(context.TODO(), i, tags); err != nil {
+		t.Fatal("ArchiveImage failed: ", err)
+	}
+}
+
+func TestDeprecateImage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	deprecateAt := time.Date(2006, 1, 2, 15, 4, 0, 0, time.UTC)
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().EnableImageDeprecationWithContext(
+		context.TODO(),
+		&ec2.EnableImageDeprecationInput{
+			ImageId:     aws.String("ami-1234567890abcdef0"),
+			DeprecateAt: aws.Time(deprecateAt),
+		}).Return(&ec2.EnableImageDeprecationOutput{}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	if err := client.DeprecateImage(context.TODO(), "ami-1234567890abcdef0", deprecateAt); err != nil {
+		t.Fatal("DeprecateImage failed: ", err)
+	}
+}
+
+func TestDryRunCreateImage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().CreateImageWithContext(
+		context.TODO(),
+		&ec2.CreateImageInput{
+			InstanceId:  aws.String("i-1234567890abcdef0"),
+			Description: aws.String("create by go-create-image-backup"),
+			Name:        aws.String("test-200601021504"),
+			NoReboot:    aws.Bool(true),
+			DryRun:      aws.Bool(true),
+		}).Return(nil, awserr.New("DryRunOperation", "Request would have succeeded, but DryRun flag is set.", nil))
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	if err := client.DryRunCreateImage(context.TODO(), "i-1234567890abcdef0", "test-200601021504", "create by go-create-image-backup", nil); err != nil {
+		t.Fatal("DryRunCreateImage failed: ", err)
+	}
+}
+
+func TestDryRunDeregisterImages_Unauthorized(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DeregisterImageWithContext(
+		context.TODO(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+			DryRun:  aws.Bool(true),
+		}).Return(nil, awserr.New("DryRunOperation", "Request would have succeeded, but DryRun flag is set.", nil))
+	mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+			DryRun:     aws.Bool(true),
+		}).Return(nil, awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil))
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	err := client.DryRunDeregisterImages(context.TODO(), []*ec2.Image{
+		{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")}},
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "UnauthorizedOperation" {
+		t.Fatalf("got %v, want UnauthorizedOperation", err)
+	}
+}
+
+func TestDryRunCreateSnapshots(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tags := []*ec2.Tag{{Key: aws.String("BackupSetId"), Value: aws.String("i-1234567890abcdef0-20060102150405")}}
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().CreateSnapshotsWithContext(
+		context.TODO(),
+		&ec2.CreateSnapshotsInput{
+			Description: aws.String("create by go-create-image-backup"),
+			InstanceSpecification: &ec2.InstanceSpecification{
+				InstanceId:           aws.String("i-1234567890abcdef0"),
+				ExcludeDataVolumeIds: []*string{aws.String("vol-1234567890abcdef1")},
+			},
+			TagSpecifications: []*ec2.TagSpecification{
+				{ResourceType: aws.String("snapshot"), Tags: tags},
+			},
+			DryRun: aws.Bool(true),
+		}).Return(nil, awserr.New("DryRunOperation", "Request would have succeeded, but DryRun flag is set.", nil))
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	if err := client.DryRunCreateSnapshots(context.TODO(), "i-1234567890abcdef0", []string{"vol-1234567890abcdef1"}, tags); err != nil {
+		t.Fatal("DryRunCreateSnapshots failed: ", err)
+	}
+}
+
+func TestDryRunDeleteSnapshots_Unauthorized(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+			DryRun:     aws.Bool(true),
+		}).Return(nil, awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil))
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	err := client.DryRunDeleteSnapshots(context.TODO(), []string{"snap-1234567890abcdef0", "snap-1234567890abcdef1"})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "UnauthorizedOperation" {
+		t.Fatalf("got %v, want UnauthorizedOperation", err)
+	}
+}
+
+func TestDryRunArchiveImage(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tags := []*ec2.Tag{{Key: aws.String("BackupTier"), Value: aws.String("archive")}}
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().ModifySnapshotTierWithContext(
+		context.TODO(),
+		&ec2.ModifySnapshotTierInput{
+			SnapshotId:  aws.String("snap-1234567890abcdef0"),
+			StorageTier: aws.String("archive"),
+			DryRun:      aws.Bool(true),
+		}).Return(nil, awserr.New("DryRunOperation", "Request would have succeeded, but DryRun flag is set.", nil))
+	mockEC2.EXPECT().CreateTagsWithContext(
+		context.TODO(),
+		&ec2.CreateTagsInput{
+			Resources: []*string{aws.String("ami-1234567890abcdef0"), aws.String("snap-1234567890abcdef0")},
+			Tags:      tags,
+			DryRun:    aws.Bool(true),
+		}).Return(nil, awserr.New("DryRunOperation", "Request would have succeeded, but DryRun flag is set.", nil))
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	err := client.DryRunArchiveImage(context.TODO(), &ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")}},
+		},
+	}, tags)
+	if err != nil {
+		t.Fatal("DryRunArchiveImage failed: ", err)
+	}
+}
+
+func TestFindImages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeImagesWithContext(
+		context.TODO(),
+		&ec2.DescribeImagesInput{
+			Owners: []*string{aws.String("self")},
+			Filters: []*ec2.Filter{
+				{Name: aws.String("image-id"), Values: []*string{aws.String("ami-1234567890abcdef0"), aws.String("ami-1234567890abcdef1")}},
+			},
+		}).Return(&ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{{ImageId: aws.String("ami-1234567890abcdef0")}},
+	}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.FindImages(context.TODO(), []string{"ami-1234567890abcdef0", "ami-1234567890abcdef1"})
+	if err != nil {
+		t.Fatal("FindImages failed: ", err)
+	}
+
+	want := []*ec2.Image{{ImageId: aws.String("ami-1234567890abcdef0")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestFindSnapshots(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeSnapshotsPagesWithContext(
+		context.TODO(),
+		&ec2.DescribeSnapshotsInput{
+			OwnerIds: []*string{aws.String("self")},
+			Filters: []*ec2.Filter{
+				{Name: aws.String("snapshot-id"), Values: []*string{aws.String("snap-1234567890abcdef0"), aws.String("snap-1234567890abcdef1")}},
+			},
+		},
+		gomock.Any(),
+	).Do(func(ctx aws.Context, input *ec2.DescribeSnapshotsInput, fn func(*ec2.DescribeSnapshotsOutput, bool) bool) {
+		fn(&ec2.DescribeSnapshotsOutput{
+			Snapshots: []*ec2.Snapshot{{SnapshotId: aws.String("snap-1234567890abcdef1")}},
+		}, true)
+	}).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.FindSnapshots(context.TODO(), []string{"snap-1234567890abcdef0", "snap-1234567890abcdef1"})
+	if err != nil {
+		t.Fatal("FindSnapshots failed: ", err)
+	}
+
+	want := []*ec2.Snapshot{{SnapshotId: aws.String("snap-1234567890abcdef1")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestGetSnapshots_NoDevice(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
@@ -445,14 +1404,12 @@ func TestGetSnapshots_Multi(t *testing.T) {
 			{
 				BlockDeviceMappings: []*ec2.BlockDeviceMapping{
 					{
-						Ebs: &ec2.EbsBlockDevice{
-							SnapshotId: aws.String("snap-1234567890abcdef0"),
-						},
+						DeviceName: aws.String("/dev/xvda"),
+						Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")},
 					},
 					{
-						Ebs: &ec2.EbsBlockDevice{
-							SnapshotId: aws.String("snap-1234567890abcdef1"),
-						},
+						DeviceName: aws.String("/dev/sdf"),
+						NoDevice:   aws.String(""),
 					},
 				},
 			},
@@ -463,15 +1420,14 @@ func TestGetSnapshots_Multi(t *testing.T) {
 		svcEC2: mockEC2,
 	}
 
-	s, err := client.GetSnapshots(context.TODO(), "ami-1234567890abcdef0")
+	got, err := client.GetSnapshots(context.TODO(), "ami-1234567890abcdef0")
 	if err != nil {
 		t.Fatal("GetSnapshots: ", err)
 	}
 
-	got := len(s)
-	want := 2
-	if got != want {
-		t.Fatalf("got %d, want %d", got, want)
+	want := []string{"snap-1234567890abcdef0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
 	}
 }
 
@@ -485,9 +1441,11 @@ func TestDeregisterImages(t *testing.T) {
 		&ec2.DeregisterImageInput{
 			ImageId: aws.String("ami-1234567890abcdef0"),
 		}).Return(&ec2.DeregisterImageOutput{}, nil)
-	mockEC2.EXPECT().DeleteSnapshot(&ec2.DeleteSnapshotInput{
-		SnapshotId: aws.String("snap-1234567890abcdef0"),
-	}).Return(&ec2.DeleteSnapshotOutput{}, nil)
+	mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+		}).Return(&ec2.DeleteSnapshotOutput{}, nil)
 
 	client := AWSClient{
 		svcEC2: mockEC2,
@@ -506,6 +1464,115 @@ func TestDeregisterImages(t *testing.T) {
 	}
 }
 
+func TestDeregisterImages_PartialFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	throttled := mockEC2.EXPECT().DeregisterImageWithContext(
+		context.TODO(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+		}).Return(nil, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil))
+	mockEC2.EXPECT().DeregisterImageWithContext(
+		context.TODO(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+		}).Return(&ec2.DeregisterImageOutput{}, nil).After(throttled)
+	inUse := mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+		}).Return(nil, awserr.New("InvalidSnapshot.InUse", "The snapshot is currently in use.", nil))
+	mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+		}).Return(&ec2.DeleteSnapshotOutput{}, nil).After(inUse)
+	mockEC2.EXPECT().DeregisterImageWithContext(
+		context.TODO(),
+		&ec2.DeregisterImageInput{
+			ImageId: aws.String("ami-1234567890abcdef1"),
+		}).Return(nil, awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil))
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	i := []*ec2.Image{
+		{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")}},
+			},
+		},
+		{
+			ImageId: aws.String("ami-1234567890abcdef1"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef1")}},
+			},
+		},
+	}
+	err := client.DeregisterImages(context.TODO(), i)
+	deregisterErr, ok := err.(*deregisterError)
+	if !ok {
+		t.Fatalf("got %v, want deregisterError", err)
+	}
+	if deregisterErr.failed("ami-1234567890abcdef0") || !deregisterErr.failed("ami-1234567890abcdef1") {
+		t.Fatalf("got %v, want failure of ami-1234567890abcdef1", err)
+	}
+
+	want := "failed to deregister 1 of 3 images and snapshots: ami-1234567890abcdef1: UnauthorizedOperation: You are not authorized to perform this operation."
+	if err.Error() != want {
+		t.Fatalf("got %s, want %s", err.Error(), want)
+	}
+}
+
+func TestDeleteSnapshots_PartialFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	throttled := mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+		}).Return(nil, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil))
+	mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+		}).Return(&ec2.DeleteSnapshotOutput{}, nil).After(throttled)
+	mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef1"),
+		}).Return(nil, awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil))
+	mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef2"),
+		}).Return(nil, awserr.New("InvalidSnapshot.NotFound", "The snapshot does not exist.", nil))
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	err := client.DeleteSnapshots(context.TODO(), []string{"snap-1234567890abcdef0", "snap-1234567890abcdef1", "snap-1234567890abcdef2"})
+	deleteErr, ok := err.(*deleteSnapshotsError)
+	if !ok {
+		t.Fatalf("got %v, want deleteSnapshotsError", err)
+	}
+	if deleteErr.failed("snap-1234567890abcdef0") || !deleteErr.failed("snap-1234567890abcdef1") || deleteErr.failed("snap-1234567890abcdef2") {
+		t.Fatalf("got %v, want failure of snap-1234567890abcdef1", err)
+	}
+
+	want := "failed to delete 1 of 3 snapshots: snap-1234567890abcdef1: UnauthorizedOperation: You are not authorized to perform this operation."
+	if err.Error() != want {
+		t.Fatalf("got %s, want %s", err.Error(), want)
+	}
+}
+
 func TestDeregisterImagesUseEphemeralDisk(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -516,9 +1583,11 @@ func TestDeregisterImagesUseEphemeralDisk(t *testing.T) {
 		&ec2.DeregisterImageInput{
 			ImageId: aws.String("ami-1234567890abcdef0"),
 		}).Return(&ec2.DeregisterImageOutput{}, nil)
-	mockEC2.EXPECT().DeleteSnapshot(&ec2.DeleteSnapshotInput{
-		SnapshotId: aws.String("snap-1234567890abcdef0"),
-	}).Return(&ec2.DeleteSnapshotOutput{}, nil)
+	mockEC2.EXPECT().DeleteSnapshotWithContext(
+		context.TODO(),
+		&ec2.DeleteSnapshotInput{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+		}).Return(&ec2.DeleteSnapshotOutput{}, nil)
 
 	client := AWSClient{
 		svcEC2: mockEC2,
@@ -542,3 +1611,130 @@ func TestDeregisterImagesUseEphemeralDisk(t *testing.T) {
 		t.Fatal("DeregisterImages failed: ", err)
 	}
 }
+
+func TestGetInstance(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().DescribeInstancesWithContext(
+		context.TODO(),
+		&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("instance-id"), Values: []*string{aws.String("i-1234567890abcdef0")}},
+			},
+		}).Return(&ec2.DescribeInstancesOutput{}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.GetInstance(context.TODO(), "i-1234567890abcdef0")
+	if err != nil {
+		t.Fatal("GetInstance failed: ", err)
+	}
+
+	if got != nil {
+		t.Fatalf("got %v, want nil", got)
+	}
+}
+
+func TestRunInstance(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().RunInstancesWithContext(
+		context.TODO(),
+		&ec2.RunInstancesInput{
+			ImageId:      aws.String("ami-1234567890abcdef0"),
+			InstanceType: aws.String("t3.micro"),
+			MinCount:     aws.Int64(1),
+			MaxCount:     aws.Int64(1),
+		}).Return(&ec2.Reservation{
+		Instances: []*ec2.Instance{{InstanceId: aws.String("i-1234567890abcdef0")}},
+	}, nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.RunInstance(context.TODO(), &ec2.RunInstancesInput{
+		ImageId:      aws.String("ami-1234567890abcdef0"),
+		InstanceType: aws.String("t3.micro"),
+	})
+	if err != nil {
+		t.Fatal("RunInstance failed: ", err)
+	}
+
+	if got != "i-1234567890abcdef0" {
+		t.Fatalf("got %s, want i-1234567890abcdef0", got)
+	}
+}
+
+func TestCreateVolume(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tags := []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}}
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().CreateVolumeWithContext(
+		context.TODO(),
+		&ec2.CreateVolumeInput{
+			SnapshotId:        aws.String("snap-1234567890abcdef0"),
+			AvailabilityZone:  aws.String("ap-northeast-1a"),
+			VolumeType:        aws.String("io2"),
+			Iops:              aws.Int64(4000),
+			TagSpecifications: []*ec2.TagSpecification{{ResourceType: aws.String("volume"), Tags: tags}},
+		}).Return(&ec2.Volume{VolumeId: aws.String("vol-1234567890abcdef0")}, nil)
+	mockEC2.EXPECT().WaitUntilVolumeAvailableWithContext(
+		context.TODO(),
+		&ec2.DescribeVolumesInput{
+			VolumeIds: []*string{aws.String("vol-1234567890abcdef0")},
+		},
+		gomock.Any(),
+	).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	got, err := client.CreateVolume(context.TODO(), "snap-1234567890abcdef0", "ap-northeast-1a", "io2", 4000, 0, tags)
+	if err != nil {
+		t.Fatal("CreateVolume failed: ", err)
+	}
+
+	if got != "vol-1234567890abcdef0" {
+		t.Fatalf("got %s, want vol-1234567890abcdef0", got)
+	}
+}
+
+func TestAttachVolume(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockEC2 := mock.NewMockEC2API(mockCtrl)
+	mockEC2.EXPECT().AttachVolumeWithContext(
+		context.TODO(),
+		&ec2.AttachVolumeInput{
+			VolumeId:   aws.String("vol-1234567890abcdef0"),
+			InstanceId: aws.String("i-1234567890abcdef0"),
+			Device:     aws.String("/dev/sdf"),
+		}).Return(&ec2.VolumeAttachment{}, nil)
+	mockEC2.EXPECT().WaitUntilVolumeInUseWithContext(
+		context.TODO(),
+		&ec2.DescribeVolumesInput{
+			VolumeIds: []*string{aws.String("vol-1234567890abcdef0")},
+		},
+		gomock.Any(),
+	).Return(nil)
+
+	client := AWSClient{
+		svcEC2: mockEC2,
+	}
+
+	if err := client.AttachVolume(context.TODO(), "vol-1234567890abcdef0", "i-1234567890abcdef0", "/dev/sdf"); err != nil {
+		t.Fatal("AttachVolume failed: ", err)
+	}
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 	"unicode"
 
@@ -12,14 +15,115 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
+// DefaultGroupBy is keys of tags which identify the generation management group by default.
+var DefaultGroupBy = []string{TagName, TagService}
+
+// InstanceIDGroupBy is keys of tags which identify the generation management group by the instance id,
+// which does not change even if Name tag of the instance is changed.
+var InstanceIDGroupBy = []string{TagInstanceID, TagService}
+
 // Backup provides methods for backup operations.
 type Backup struct {
 	InstanceID string
 	Name       string
 	Generation int
-	Service    string
-	CustomTags []Tag
-	Client     AWS
+	// Retention keeps machine images per day, week, month and year instead of Generation unless it is empty.
+	Retention RetentionPolicy
+	// MaxAge deregisters machine images older than it instead of Generation unless it is zero,
+	// but keeps MinGenerations newest machine images even if these are older.
+	MaxAge         time.Duration
+	MinGenerations int
+	// ArchiveGeneration is the number of backup generation in the archive tier after Generation.
+	ArchiveGeneration int
+	Service           string
+	// DeprecateAfter is the duration after which a new machine image is deprecated, zero means no deprecation.
+	DeprecateAfter time.Duration
+	// BackupInterval deprecates a new machine image after Generation x BackupInterval instead of DeprecateAfter unless it is zero.
+	BackupInterval time.Duration
+	// RotateDeprecated deregisters deprecated machine images even if these are within Generation.
+	RotateDeprecated bool
+	CustomTags       []Tag
+	// NameTemplate and DescriptionTemplate are templates of machine image name and description, nil means default.
+	NameTemplate        *template.Template
+	DescriptionTemplate *template.Template
+	// ShareWith is AWS account ids, organization ARNs or organizational unit ARNs to share backups with.
+	ShareWith []string
+	Exclude   ExcludeVolumes
+	// Schema is keys and values of tags which are written by backup.
+	Schema TagSchema
+	// GroupBy is keys of tags which identify the generation management group,
+	// these are Name, Service, InstanceId or keys of CustomTags. Empty means DefaultGroupBy.
+	GroupBy []string
+	// PropagateTags selects tags of the instance and volumes which are copied to machine image and snapshots.
+	PropagateTags TagFilter
+	Client        AWS
+}
+
+// TagFilter selects tags by keys or a regular expression of keys.
+type TagFilter struct {
+	Keys    []string
+	Pattern *regexp.Regexp
+}
+
+func (f *TagFilter) isEmpty() bool {
+	return len(f.Keys) == 0 && f.Pattern == nil
+}
+
+// filter returns tags which are selected by the filter except tags which have the same key as exclude.
+// Tags which have aws: prefix are reserved by AWS, so that these are never selected.
+func (f *TagFilter) filter(tags []*ec2.Tag, exclude []*ec2.Tag) []*ec2.Tag {
+	var filtered []*ec2.Tag
+	for _, t := range tags {
+		key := aws.StringValue(t.Key)
+		if strings.HasPrefix(key, "aws:") || hasTag(exclude, key) {
+			continue
+		}
+
+		match := f.Pattern != nil && f.Pattern.MatchString(key)
+		for _, k := range f.Keys {
+			if k == key {
+				match = true
+			}
+		}
+		if match {
+			filtered = append(filtered, &ec2.Tag{Key: t.Key, Value: t.Value})
+		}
+	}
+	return filtered
+}
+
+// ExcludeVolumes specifies EBS volumes which are not included in backup.
+type ExcludeVolumes struct {
+	Devices   []string
+	VolumeIDs []string
+	// Tags excludes volumes which have any of the tags.
+	Tags []Tag
+}
+
+func (e *ExcludeVolumes) isEmpty() bool {
+	return len(e.Devices) == 0 && len(e.VolumeIDs) == 0 && len(e.Tags) == 0
+}
+
+// match returns whether the volume attached at the device is excluded.
+func (e *ExcludeVolumes) match(volume *ec2.Volume, device string) bool {
+	for _, d := range e.Devices {
+		if d == device {
+			return true
+		}
+	}
+	for _, id := range e.VolumeIDs {
+		if id == aws.StringValue(volume.VolumeId) {
+			return true
+		}
+	}
+	for _, t := range e.Tags {
+		for _, vt := range volume.Tags {
+			if t.Key == aws.StringValue(vt.Key) && t.Value == aws.StringValue(vt.Value) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // Tag is key-value formatted metadata for backup
@@ -30,13 +134,71 @@ type Tag struct {
 
 // Create Amazon Machine Image(AMI) as instance's backup.
 func (b *Backup) Create(ctx context.Context) (string, error) {
-	const layout = "200601021504"
-	now := time.Now().Format(layout)
+	imageName, description, err := b.imageName(ctx, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	volumes, err := b.Client.GetInstanceVolumes(ctx, b.InstanceID)
+	if err != nil {
+		return "", err
+	}
 
-	imageName := b.Name
+	excludeDevices, _ := b.excludeVolumes(volumes)
+
+	imageID, err := b.Client.CreateImage(ctx, b.InstanceID, imageName, description, excludeDevices)
+	if err != nil {
+		return "", err
+	}
+
+	tag := b.tags()
+	imageTag := tag
+	if !b.PropagateTags.isEmpty() {
+		instanceTags, err := b.Client.GetInstanceTags(ctx, b.InstanceID)
+		if err != nil {
+			return "", err
+		}
+		imageTag = append(append([]*ec2.Tag{}, tag...), b.PropagateTags.filter(instanceTags, tag)...)
+	}
+
+	if err := b.tagImage(ctx, b.Client, imageID, imageTag, tag, b.deviceVolumes(volumes)); err != nil {
+		return "", err
+	}
+
+	if deprecateAfter := b.deprecateAfter(); deprecateAfter > 0 {
+		if err := b.Client.DeprecateImage(ctx, imageID, time.Now().Add(deprecateAfter)); err != nil {
+			return "", err
+		}
+	}
+
+	if len(b.ShareWith) > 0 {
+		if err := b.Client.ShareImage(ctx, imageID, b.ShareWith); err != nil {
+			return "", err
+		}
+	}
+
+	return imageID, nil
+}
+
+// deviceVolumes returns EBS volumes attached to the instance by device name.
+func (b *Backup) deviceVolumes(volumes []*ec2.Volume) map[string]*ec2.Volume {
+	deviceVolumes := make(map[string]*ec2.Volume)
+	for _, v := range volumes {
+		for _, a := range v.Attachments {
+			if aws.StringValue(a.InstanceId) == b.InstanceID {
+				deviceVolumes[aws.StringValue(a.Device)] = v
+			}
+		}
+	}
+	return deviceVolumes
+}
+
+// templateData returns the data to render templates of machine image name and description.
+func (b *Backup) templateData(now time.Time) TemplateData {
+	name := b.Name
 
 	isASCII := true
-	for _, c := range []byte(imageName) {
+	for _, c := range []byte(name) {
 		if c > unicode.MaxASCII {
 			isASCII = false
 			break
@@ -44,27 +206,316 @@ func (b *Backup) Create(ctx context.Context) (string, error) {
 	}
 
 	if !isASCII {
-		imageName = b.InstanceID
+		name = b.InstanceID
+	}
+
+	tags := make(map[string]string)
+	for _, t := range b.CustomTags {
+		tags[t.Key] = t.Value
+	}
+
+	return TemplateData{
+		InstanceID: b.InstanceID,
+		Name:       name,
+		Service:    b.Service,
+		Time:       now.Local(),
+		UTC:        now.UTC(),
+		Sequence:   1,
+		Tags:       tags,
+	}
+}
+
+// imageName renders machine image name and description by templates.
+// The sequence number is incremented until the name does not collide with an existing machine image.
+func (b *Backup) imageName(ctx context.Context, now time.Time) (string, string, error) {
+	nameTemplate := b.NameTemplate
+	if nameTemplate == nil {
+		nameTemplate = template.Must(ParseTemplate("name", DefaultNameTemplate))
+	}
+	descriptionTemplate := b.DescriptionTemplate
+	if descriptionTemplate == nil {
+		descriptionTemplate = template.Must(ParseTemplate("description", DefaultDescriptionTemplate))
+	}
+
+	data := b.templateData(now)
+
+	var name, prevName string
+	for ; data.Sequence <= maxSequence; data.Sequence++ {
+		var err error
+		name, err = renderTemplate(nameTemplate, data)
+		if err != nil {
+			return "", "", err
+		}
+		if err := validateImageName(name); err != nil {
+			return "", "", err
+		}
+
+		// the name does not change by the sequence number, so that it collides forever
+		if name == prevName {
+			return "", "", fmt.Errorf("image name already exists: %s", name)
+		}
+		prevName = name
+
+		exists, err := b.Client.ImageNameExists(ctx, name)
+		if err != nil {
+			return "", "", err
+		}
+		if !exists {
+			break
+		}
+	}
+	if data.Sequence > maxSequence {
+		return "", "", fmt.Errorf("image name already exists: %s", name)
+	}
+
+	description, err := renderTemplate(descriptionTemplate, data)
+	if err != nil {
+		return "", "", err
+	}
+	if err := validateImageDescription(description); err != nil {
+		return "", "", err
+	}
+
+	return name, description, nil
+}
+
+// excludeVolumes returns device names and volume ids of EBS volumes which are excluded from backup.
+func (b *Backup) excludeVolumes(volumes []*ec2.Volume) ([]string, []string) {
+	var devices, volumeIDs []string
+	for _, v := range volumes {
+		for _, a := range v.Attachments {
+			if aws.StringValue(a.InstanceId) != b.InstanceID {
+				continue
+			}
+			if b.Exclude.match(v, aws.StringValue(a.Device)) {
+				devices = append(devices, aws.StringValue(a.Device))
+				volumeIDs = append(volumeIDs, aws.StringValue(v.VolumeId))
+			}
+		}
+	}
+
+	return devices, volumeIDs
+}
+
+// CreateSnapshotSet creates crash-consistent snapshots of all EBS volumes of instance as a backup without machine image.
+// The snapshots are grouped by BackupSetId tag, and returns the backup set id.
+func (b *Backup) CreateSnapshotSet(ctx context.Context) (string, error) {
+	setID := b.snapshotSetID(time.Now())
+
+	var excludeVolumeIDs []string
+	if !b.Exclude.isEmpty() {
+		volumes, err := b.Client.GetInstanceVolumes(ctx, b.InstanceID)
+		if err != nil {
+			return "", err
+		}
+		_, excludeVolumeIDs = b.excludeVolumes(volumes)
+	}
+
+	tag := append(b.tags(), b.Schema.Tag(TagBackupSetID, setID))
+
+	if _, err := b.Client.CreateSnapshots(ctx, b.InstanceID, excludeVolumeIDs, tag); err != nil {
+		return "", err
+	}
+
+	return setID, nil
+}
+
+// RotateSnapshotSets deletes snapshots of old snapshot-only backups which greater than generation.
+// Backup sets whose snapshots are all deleted are returned with the error when the others failed.
+func (b *Backup) RotateSnapshotSets(ctx context.Context, recentlySetID string) ([]string, error) {
+	var rotateSetIDs []string
+
+	setIDs, setSnapshots, err := b.expiredSnapshotSets(ctx, recentlySetID)
+	if err != nil {
+		return rotateSetIDs, err
+	}
+
+	var errList []string
+	for _, setID := range setIDs {
+		if err := b.Client.DeleteSnapshots(ctx, setSnapshots[setID]); err != nil {
+			errList = append(errList, fmt.Sprintf("%s: %s", setID, err.Error()))
+			continue
+		}
+		rotateSetIDs = append(rotateSetIDs, setID)
+	}
+
+	if len(errList) > 0 {
+		return rotateSetIDs, fmt.Errorf("failed to delete %d of %d snapshot sets: %s", len(errList), len(setIDs), strings.Join(errList, ", "))
+	}
+	return rotateSetIDs, nil
+}
+
+// snapshotSetID returns the id of backup set of snapshot-only backup which is created at the time.
+func (b *Backup) snapshotSetID(t time.Time) string {
+	const layout = "20060102150405"
+	return fmt.Sprintf("%s-%s", b.InstanceID, t.Format(layout))
+}
+
+// expiredSnapshotSets returns ids of old snapshot-only backups which greater than generation in ascending order
+// of backup time, and snapshot ids of each backup set. The recently backup set is counted unless the id is empty.
+func (b *Backup) expiredSnapshotSets(ctx context.Context, recentlySetID string) ([]string, map[string][]string, error) {
+	setKey := b.Schema.Key(TagBackupSetID)
+	snapshots, err := b.Client.GetTaggedSnapshots(ctx, b.groupTags(), []string{setKey})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// snapshots of a backup set are created at the same time, so that the oldest start time is the backup time.
+	setSnapshots := make(map[string][]string)
+	setTimes := make(map[string]time.Time)
+	for _, s := range snapshots {
+		setID := tagValue(s.Tags, setKey)
+		setSnapshots[setID] = append(setSnapshots[setID], *s.SnapshotId)
+		if t, ok := setTimes[setID]; !ok || aws.TimeValue(s.StartTime).Before(t) {
+			setTimes[setID] = aws.TimeValue(s.StartTime)
+		}
+	}
+
+	// the recently backup set may not be found yet
+	if _, ok := setTimes[recentlySetID]; !ok && recentlySetID != "" {
+		setTimes[recentlySetID] = time.Now()
+	}
+
+	if len(setTimes) <= b.Generation {
+		return nil, setSnapshots, nil
+	}
+
+	var setIDs []string
+	for setID := range setTimes {
+		setIDs = append(setIDs, setID)
+	}
+	sort.Slice(setIDs, func(i, j int) bool {
+		return setTimes[setIDs[i]].Before(setTimes[setIDs[j]])
+	})
+
+	return setIDs[:len(setIDs)-b.Generation], setSnapshots, nil
+}
+
+// Copy copies the machine image to the region of the dst backup, and tags the copied image same as the backup.
+// The copied image is deprecated by the generation of the dst backup when it differs from the backup.
+func (b *Backup) Copy(ctx context.Context, imageID string, dst *Backup) (string, error) {
+	return b.copyImage(ctx, imageID, dst.Client, dst.deprecateAfter(), "")
+}
+
+// Encrypt copies the machine image to the region of dst with encryption by the KMS key,
+// and tags the encrypted image same as the backup.
+func (b *Backup) Encrypt(ctx context.Context, imageID string, dst AWS, kmsKeyID string) (string, error) {
+	return b.copyImage(ctx, imageID, dst, b.deprecateAfter(), kmsKeyID)
+}
+
+func (b *Backup) copyImage(ctx context.Context, imageID string, dst AWS, deprecateAfter time.Duration, kmsKeyID string) (string, error) {
+	region, err := b.Client.GetRegion()
+	if err != nil {
+		return "", err
 	}
 
-	imageID, err := b.Client.CreateImage(ctx, b.InstanceID, imageName, now)
+	image, err := b.Client.GetImage(ctx, imageID)
 	if err != nil {
 		return "", err
 	}
 
+	tag := b.tags()
+	if !b.PropagateTags.isEmpty() {
+		tag = append(tag, b.PropagateTags.filter(image.Tags, tag)...)
+	}
+
+	// machine image name must be unique in a region, so that encrypted image has a different name from the source
+	name := aws.StringValue(image.Name)
+	if kmsKeyID != "" {
+		name = encryptedImageName(name)
+		tag = append(tag, b.Schema.Tag(TagSourceImageID, imageID))
+	}
+
+	copiedImageID, err := dst.CopyImage(ctx, region, imageID, name, aws.StringValue(image.Description), kmsKeyID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := b.tagImage(ctx, dst, copiedImageID, tag, tag, nil); err != nil {
+		return "", err
+	}
+
+	// deprecation time is not copied by CopyImage, so that copied image is deprecated at the same time as the source
+	// unless the destination has a different retention from the source
+	var deprecateAt time.Time
+	if deprecateAfter != b.deprecateAfter() {
+		if deprecateAfter > 0 {
+			deprecateAt = time.Now().Add(deprecateAfter)
+		}
+	} else if image.DeprecationTime != nil {
+		deprecateAt = convertDate(*image.DeprecationTime)
+	}
+	if !deprecateAt.IsZero() {
+		if err := dst.DeprecateImage(ctx, copiedImageID, deprecateAt); err != nil {
+			return "", err
+		}
+	}
+
+	if len(b.ShareWith) > 0 {
+		if err := dst.ShareImage(ctx, copiedImageID, b.ShareWith); err != nil {
+			return "", err
+		}
+	}
+
+	return copiedImageID, nil
+}
+
+// deprecateAfter returns the duration after which a new machine image is deprecated.
+// Backups are expected to be retained for Generation x BackupInterval when BackupInterval is set.
+func (b *Backup) deprecateAfter() time.Duration {
+	if b.BackupInterval > 0 {
+		return time.Duration(b.Generation) * b.BackupInterval
+	}
+	return b.DeprecateAfter
+}
+
+// encryptedImageName returns the name of encrypted image of the source name,
+// which is truncated so that the name with the suffix does not exceed the maximum length.
+func encryptedImageName(name string) string {
+	const suffix = "-encrypted"
+	if len(name) > maxImageNameLength-len(suffix) {
+		name = name[:maxImageNameLength-len(suffix)]
+	}
+	return name + suffix
+}
+
+// groupBy returns keys of tags which identify the generation management group.
+func (b *Backup) groupBy() []string {
+	if len(b.GroupBy) == 0 {
+		return DefaultGroupBy
+	}
+	return b.GroupBy
+}
+
+// groupTags returns tags which identify backups of the same generation management group.
+func (b *Backup) groupTags() []*ec2.Tag {
+	tags := []*ec2.Tag{b.Schema.Tag(TagBackupType, b.Schema.BackupType())}
+	for _, key := range b.groupBy() {
+		switch key {
+		case TagName:
+			tags = append(tags, b.Schema.Tag(TagName, b.Name))
+		case TagService:
+			tags = append(tags, b.Schema.Tag(TagService, b.Service))
+		case TagInstanceID:
+			tags = append(tags, b.Schema.Tag(TagInstanceID, b.InstanceID))
+		default:
+			for _, t := range b.CustomTags {
+				if t.Key == key {
+					tags = append(tags, &ec2.Tag{Key: aws.String(t.Key), Value: aws.String(t.Value)})
+				}
+			}
+		}
+	}
+	return tags
+}
+
+// tags returns tags for machine image and snapshots of the backup.
+func (b *Backup) tags() []*ec2.Tag {
 	tag := []*ec2.Tag{
-		{
-			Key:   aws.String("BackupType"),
-			Value: aws.String("auto"),
-		},
-		{
-			Key:   aws.String("Name"),
-			Value: aws.String(b.Name),
-		},
-		{
-			Key:   aws.String("Service"),
-			Value: aws.String(b.Service),
-		},
+		b.Schema.Tag(TagBackupType, b.Schema.BackupType()),
+		b.Schema.Tag(TagName, b.Name),
+		b.Schema.Tag(TagService, b.Service),
+		b.Schema.Tag(TagInstanceID, b.InstanceID),
 	}
 
 	if len(b.CustomTags) > 0 {
@@ -78,26 +529,90 @@ func (b *Backup) Create(ctx context.Context) (string, error) {
 		tag = append(tag, customTags...)
 	}
 
-	if err := b.Client.CreateTags(ctx, imageID, tag); err != nil {
-		return "", err
+	return tag
+}
+
+// tagImage tags machine image, and each snapshot of the machine image with its device metadata.
+// When the source volume of the snapshot is found in volumes, tags of the volume are propagated to the snapshot.
+func (b *Backup) tagImage(ctx context.Context, client AWS, imageID string, imageTag, snapshotTag []*ec2.Tag, volumes map[string]*ec2.Volume) error {
+	if err := client.CreateTags(ctx, imageID, imageTag); err != nil {
+		return err
 	}
 
-	snapshots, err := b.Client.GetSnapshots(ctx, imageID)
+	image, err := client.GetImage(ctx, imageID)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	var errList []string
-	for _, snapshot := range snapshots {
-		if err := b.Client.CreateTags(ctx, snapshot, tag); err != nil {
+	for _, m := range image.BlockDeviceMappings {
+		if m.Ebs == nil || m.Ebs.SnapshotId == nil || m.NoDevice != nil {
+			continue
+		}
+
+		volume := volumes[aws.StringValue(m.DeviceName)]
+		tag := append([]*ec2.Tag{}, snapshotTag...)
+		tag = append(tag, b.deviceTags(imageID, m, volume, tag)...)
+		if volume != nil {
+			tag = append(tag, b.PropagateTags.filter(volume.Tags, tag)...)
+		}
+
+		if err := client.CreateTags(ctx, *m.Ebs.SnapshotId, tag); err != nil {
 			errList = append(errList, err.Error())
 		}
 	}
 	if len(errList) > 0 {
-		return "", fmt.Errorf(strings.Join(errList, ", "))
+		return fmt.Errorf(strings.Join(errList, ", "))
 	}
 
-	return imageID, nil
+	return nil
+}
+
+// deviceTags returns tags of device metadata of the snapshot in the block device mapping
+// except tags which have the same key as exclude.
+func (b *Backup) deviceTags(imageID string, m *ec2.BlockDeviceMapping, volume *ec2.Volume, exclude []*ec2.Tag) []*ec2.Tag {
+	var volumeID, volumeSize *string
+	if volume != nil {
+		volumeID = volume.VolumeId
+	}
+	if m.Ebs.VolumeSize != nil {
+		volumeSize = aws.String(strconv.FormatInt(*m.Ebs.VolumeSize, 10))
+	}
+
+	var tags []*ec2.Tag
+	for _, t := range []*ec2.Tag{
+		{Key: aws.String(b.Schema.Key(TagDeviceName)), Value: m.DeviceName},
+		{Key: aws.String(b.Schema.Key(TagVolumeID)), Value: volumeID},
+		{Key: aws.String(b.Schema.Key(TagVolumeType)), Value: m.Ebs.VolumeType},
+		{Key: aws.String(b.Schema.Key(TagVolumeSize)), Value: volumeSize},
+		{Key: aws.String(b.Schema.Key(TagImageID)), Value: aws.String(imageID)},
+	} {
+		if t.Value == nil || hasTag(exclude, *t.Key) {
+			continue
+		}
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// hasTag returns whether tags have the tag key.
+func hasTag(tags []*ec2.Tag, key string) bool {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// tagValue returns value of the tag key, or empty string if the tag key not found.
+func tagValue(tags []*ec2.Tag, key string) string {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key {
+			return aws.StringValue(t.Value)
+		}
+	}
+	return ""
 }
 
 func convertDate(baseStr string) time.Time {
@@ -110,13 +625,13 @@ func convertDate(baseStr string) time.Time {
 	return t
 }
 
-// Rotate deregisters of old machine image which greater than generation.
-func (b *Backup) Rotate(ctx context.Context, recentlyImageID string) ([]string, error) {
-	var rotateImageIDs []string
-
-	images, err := b.Client.GetImages(ctx, b.Name, b.Service)
+// images returns machine images of the backup sorted by creation date in ascending order,
+// and encrypted images of each image which are rotated together with the source image.
+// The recently created image is added when it is not found yet, unless the id is empty.
+func (b *Backup) images(ctx context.Context, recentlyImageID string) ([]*ec2.Image, map[string][]*ec2.Image, error) {
+	images, err := b.Client.GetImages(ctx, b.groupTags())
 	if err != nil {
-		return rotateImageIDs, err
+		return nil, nil, err
 	}
 
 	var hasRecentlyImageID bool
@@ -126,17 +641,30 @@ func (b *Backup) Rotate(ctx context.Context, recentlyImageID string) ([]string,
 		}
 	}
 
-	if !hasRecentlyImageID {
+	if !hasRecentlyImageID && recentlyImageID != "" {
 		recentlyImage, err := b.Client.GetImage(ctx, recentlyImageID)
 		if err != nil {
-			return rotateImageIDs, err
+			return nil, nil, err
 		}
 		images = append(images, recentlyImage)
 	}
 
-	if len(images) <= b.Generation {
-		return rotateImageIDs, nil
+	// encrypted images whose source image still exists are not counted as a generation,
+	// these are rotated together with the source image.
+	imageIDs := make(map[string]bool)
+	for _, image := range images {
+		imageIDs[*image.ImageId] = true
 	}
+	encryptedImages := make(map[string][]*ec2.Image)
+	var sourceImages []*ec2.Image
+	for _, image := range images {
+		if s := tagValue(image.Tags, b.Schema.Key(TagSourceImageID)); s != "" && imageIDs[s] {
+			encryptedImages[s] = append(encryptedImages[s], image)
+			continue
+		}
+		sourceImages = append(sourceImages, image)
+	}
+	images = sourceImages
 
 	for _, image := range images {
 		if *image.State == "failed" {
@@ -155,13 +683,175 @@ func (b *Backup) Rotate(ctx context.Context, recentlyImageID string) ([]string,
 		return iDate.Before(jDate)
 	})
 
-	rotateIndex := len(images) - b.Generation
-	if err := b.Client.DeregisterImages(ctx, images[:rotateIndex]); err != nil {
+	return images, encryptedImages, nil
+}
+
+// splitArchived splits machine images into images in the standard tier and images in the archive tier.
+func (b *Backup) splitArchived(images []*ec2.Image) ([]*ec2.Image, []*ec2.Image) {
+	var standard, archived []*ec2.Image
+	for _, image := range images {
+		if tagValue(image.Tags, b.Schema.Key(TagBackupTier)) == "archive" {
+			archived = append(archived, image)
+			continue
+		}
+		standard = append(standard, image)
+	}
+	return standard, archived
+}
+
+// Archive moves snapshots of old machine images which greater than generation to the archive tier.
+// These images are counted as archive generation by Rotate.
+func (b *Backup) Archive(ctx context.Context, recentlyImageID string) ([]string, error) {
+	var archiveImageIDs []string
+
+	if b.ArchiveGeneration < 1 {
+		return archiveImageIDs, nil
+	}
+
+	images, encryptedImages, err := b.images(ctx, recentlyImageID)
+	if err != nil {
+		return archiveImageIDs, err
+	}
+
+	for _, image := range b.archiveCandidates(images) {
+		for _, i := range append([]*ec2.Image{image}, encryptedImages[*image.ImageId]...) {
+			if err := b.Client.ArchiveImage(ctx, i, []*ec2.Tag{b.Schema.Tag(TagBackupTier, "archive")}); err != nil {
+				return archiveImageIDs, err
+			}
+			archiveImageIDs = append(archiveImageIDs, *i.ImageId)
+		}
+	}
+
+	return archiveImageIDs, nil
+}
+
+// archiveCandidates returns available machine images in the standard tier which greater than generation.
+// Images which are not available are deregistered by Rotate instead of archive.
+func (b *Backup) archiveCandidates(images []*ec2.Image) []*ec2.Image {
+	standard, _ := b.splitArchived(images)
+	if len(standard) <= b.Generation {
+		return nil
+	}
+
+	var candidates []*ec2.Image
+	for _, image := range standard[:len(standard)-b.Generation] {
+		if *image.State == "available" {
+			candidates = append(candidates, image)
+		}
+	}
+	return candidates
+}
+
+// Rotate deregisters of old machine image which greater than generation.
+// Machine images in the archive tier are deregistered when greater than archive generation.
+// When the retention policy is set, machine images which are not kept by the policy are deregistered instead.
+// When the max age is set, machine images older than it are deregistered instead, but not less than min generations.
+func (b *Backup) Rotate(ctx context.Context, recentlyImageID string) ([]string, error) {
+	var rotateImageIDs []string
+
+	images, encryptedImages, err := b.images(ctx, recentlyImageID)
+	if err != nil {
 		return rotateImageIDs, err
 	}
-	for _, i := range images[:rotateIndex] {
+
+	expiredImages := b.expiredImages(images, encryptedImages, recentlyImageID)
+	if len(expiredImages) < 1 {
+		return rotateImageIDs, nil
+	}
+
+	var rotateImages []*ec2.Image
+	for _, e := range expiredImages {
+		rotateImages = append(rotateImages, e.Image)
+	}
+
+	// revoke permissions before deregister, so that shared snapshots are not left in use by other accounts.
+	// images may be shared by former runs even if ShareWith is empty now.
+	for _, image := range rotateImages {
+		if err := b.Client.UnshareImage(ctx, image); err != nil {
+			return rotateImageIDs, err
+		}
+	}
+
+	// images which are deregistered are returned with the error when the others failed
+	err = b.Client.DeregisterImages(ctx, rotateImages)
+	deregisterErr, partial := err.(*deregisterError)
+	if err != nil && !partial {
+		return rotateImageIDs, err
+	}
+	for _, i := range rotateImages {
+		if partial && deregisterErr.failed(*i.ImageId) {
+			continue
+		}
 		rotateImageIDs = append(rotateImageIDs, *i.ImageId)
 	}
 
 	return rotateImageIDs, err
 }
+
+// ExpiredImage is a machine image which is deregistered by Rotate with the reason.
+type ExpiredImage struct {
+	Image  *ec2.Image
+	Reason string
+}
+
+// expiredImages returns machine images to deregister by generation, retention policy, max age,
+// deprecation and archive generation. Encrypted copies follow their source images.
+func (b *Backup) expiredImages(images []*ec2.Image, encryptedImages map[string][]*ec2.Image, recentlyImageID string) []ExpiredImage {
+	standard, archived := b.splitArchived(images)
+
+	var expiredImages []ExpiredImage
+	expire := func(image *ec2.Image, reason string) {
+		expiredImages = append(expiredImages, ExpiredImage{Image: image, Reason: reason})
+		for _, e := range encryptedImages[*image.ImageId] {
+			expiredImages = append(expiredImages, ExpiredImage{Image: e, Reason: fmt.Sprintf("encrypted copy of %s", *image.ImageId)})
+		}
+	}
+
+	if !b.Retention.isEmpty() {
+		keepImageIDs := b.Retention.keep(standard)
+		var kept []*ec2.Image
+		for _, image := range standard {
+			if keepImageIDs[*image.ImageId] || *image.ImageId == recentlyImageID {
+				kept = append(kept, image)
+				continue
+			}
+			expire(image, "not kept by retention policy")
+		}
+		standard = kept
+	} else if b.MaxAge > 0 {
+		expireTime := time.Now().Add(-b.MaxAge)
+		var kept []*ec2.Image
+		for i, image := range standard {
+			old := convertDate(*image.CreationDate).Before(expireTime)
+			if !old || i >= len(standard)-b.MinGenerations || *image.ImageId == recentlyImageID {
+				kept = append(kept, image)
+				continue
+			}
+			expire(image, fmt.Sprintf("older than max age %s", b.MaxAge))
+		}
+		standard = kept
+	} else if len(standard) > b.Generation {
+		for _, image := range standard[:len(standard)-b.Generation] {
+			expire(image, fmt.Sprintf("exceeds backup generation %d", b.Generation))
+		}
+		standard = standard[len(standard)-b.Generation:]
+	}
+	if b.RotateDeprecated {
+		now := time.Now()
+		for _, image := range standard {
+			if *image.ImageId == recentlyImageID || image.DeprecationTime == nil {
+				continue
+			}
+			if convertDate(*image.DeprecationTime).Before(now) {
+				expire(image, fmt.Sprintf("deprecated at %s", *image.DeprecationTime))
+			}
+		}
+	}
+	if len(archived) > b.ArchiveGeneration {
+		for _, image := range archived[:len(archived)-b.ArchiveGeneration] {
+			expire(image, fmt.Sprintf("exceeds archive generation %d", b.ArchiveGeneration))
+		}
+	}
+
+	return expiredImages
+}
@@ -2,8 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"text/template"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -11,16 +17,44 @@ import (
 	"github.com/heartbeatsjp/go-create-image-backup/mock"
 )
 
+// imageNameMatcher matches machine image name which is rendered by the default template.
+type imageNameMatcher string
+
+func (m imageNameMatcher) Matches(x interface{}) bool {
+	name, ok := x.(string)
+	return ok && regexp.MustCompile(`^`+regexp.QuoteMeta(string(m))+`-\d{12}$`).MatchString(name)
+}
+
+func (m imageNameMatcher) String() string {
+	return fmt.Sprintf("is image name of %s", string(m))
+}
+
+// groupTags returns tags of the generation management group in the default tag schema.
+func groupTags(name, service string) []*ec2.Tag {
+	return []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String(name)},
+		{Key: aws.String("Service"), Value: aws.String(service)},
+	}
+}
+
 func TestCreate(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Volume{
+		{VolumeId: aws.String("vol-1234567890abcdef0"), Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/xvda")}}},
+		{VolumeId: aws.String("vol-1234567890abcdef1"), Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/sdf")}}},
+		{VolumeId: aws.String("vol-1234567890abcdef2"), Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/sdg")}}},
+	}, nil)
+	mockAWSClient.EXPECT().ImageNameExists(context.TODO(), imageNameMatcher("test")).Return(false, nil)
 	mockAWSClient.EXPECT().CreateImage(
 		context.TODO(),
 		"i-1234567890abcdef0",
-		"test",
-		gomock.Any()).Return("ami-1234567890abcdef0", nil)
+		imageNameMatcher("test"),
+		"create by go-create-image-backup",
+		gomock.Nil()).Return("ami-1234567890abcdef0", nil)
 	createAMITag := mockAWSClient.EXPECT().CreateTags(
 		context.TODO(),
 		"ami-1234567890abcdef0",
@@ -28,12 +62,18 @@ func TestCreate(t *testing.T) {
 			{Key: aws.String("BackupType"), Value: aws.String("auto")},
 			{Key: aws.String("Name"), Value: aws.String("test")},
 			{Key: aws.String("Service"), Value: aws.String("service")},
+			{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
 			{Key: aws.String("key1"), Value: aws.String("val1")},
 			{Key: aws.String("key2"), Value: aws.String("val2")},
 		}).Return(nil)
-	mockAWSClient.EXPECT().GetSnapshots(context.TODO(), "ami-1234567890abcdef0").Return(
-		[]string{"snap-1234567890abcdef0", "snap-1234567890abcdef1", "snap-1234567890abcdef2"},
-		nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+			{DeviceName: aws.String("/dev/sdf"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef1"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+			{DeviceName: aws.String("/dev/sdg"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef2"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+		},
+	}, nil)
 	createSnapTag1 := mockAWSClient.EXPECT().CreateTags(
 		context.TODO(),
 		"snap-1234567890abcdef0",
@@ -41,8 +81,14 @@ func TestCreate(t *testing.T) {
 			{Key: aws.String("BackupType"), Value: aws.String("auto")},
 			{Key: aws.String("Name"), Value: aws.String("test")},
 			{Key: aws.String("Service"), Value: aws.String("service")},
+			{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
 			{Key: aws.String("key1"), Value: aws.String("val1")},
 			{Key: aws.String("key2"), Value: aws.String("val2")},
+			{Key: aws.String("DeviceName"), Value: aws.String("/dev/xvda")},
+			{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef0")},
+			{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+			{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+			{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
 		}).Return(nil).After(createAMITag)
 	createSnapTag2 := mockAWSClient.EXPECT().CreateTags(
 		context.TODO(),
@@ -51,8 +97,14 @@ func TestCreate(t *testing.T) {
 			{Key: aws.String("BackupType"), Value: aws.String("auto")},
 			{Key: aws.String("Name"), Value: aws.String("test")},
 			{Key: aws.String("Service"), Value: aws.String("service")},
+			{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
 			{Key: aws.String("key1"), Value: aws.String("val1")},
 			{Key: aws.String("key2"), Value: aws.String("val2")},
+			{Key: aws.String("DeviceName"), Value: aws.String("/dev/sdf")},
+			{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef1")},
+			{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+			{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+			{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
 		}).Return(nil).After(createSnapTag1)
 	mockAWSClient.EXPECT().CreateTags(
 		context.TODO(),
@@ -61,8 +113,14 @@ func TestCreate(t *testing.T) {
 			{Key: aws.String("BackupType"), Value: aws.String("auto")},
 			{Key: aws.String("Name"), Value: aws.String("test")},
 			{Key: aws.String("Service"), Value: aws.String("service")},
+			{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
 			{Key: aws.String("key1"), Value: aws.String("val1")},
 			{Key: aws.String("key2"), Value: aws.String("val2")},
+			{Key: aws.String("DeviceName"), Value: aws.String("/dev/sdg")},
+			{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef2")},
+			{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+			{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+			{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
 		}).Return(nil).After(createSnapTag2)
 
 	backup := &Backup{
@@ -92,11 +150,18 @@ func TestCreate_InstanceNameTagValue_MultiByte(t *testing.T) {
 	defer mockCtrl.Finish()
 
 	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Volume{
+		{VolumeId: aws.String("vol-1234567890abcdef0"), Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/xvda")}}},
+		{VolumeId: aws.String("vol-1234567890abcdef1"), Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/sdf")}}},
+		{VolumeId: aws.String("vol-1234567890abcdef2"), Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/sdg")}}},
+	}, nil)
+	mockAWSClient.EXPECT().ImageNameExists(context.TODO(), imageNameMatcher("i-1234567890abcdef0")).Return(false, nil)
 	mockAWSClient.EXPECT().CreateImage(
 		context.TODO(),
 		"i-1234567890abcdef0",
-		"i-1234567890abcdef0",
-		gomock.Any()).Return("ami-1234567890abcdef0", nil)
+		imageNameMatcher("i-1234567890abcdef0"),
+		"create by go-create-image-backup",
+		gomock.Nil()).Return("ami-1234567890abcdef0", nil)
 	createAMITag := mockAWSClient.EXPECT().CreateTags(
 		context.TODO(),
 		"ami-1234567890abcdef0",
@@ -104,12 +169,18 @@ func TestCreate_InstanceNameTagValue_MultiByte(t *testing.T) {
 			{Key: aws.String("BackupType"), Value: aws.String("auto")},
 			{Key: aws.String("Name"), Value: aws.String("テストサーバ")},
 			{Key: aws.String("Service"), Value: aws.String("service")},
+			{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
 			{Key: aws.String("key1"), Value: aws.String("val1")},
 			{Key: aws.String("key2"), Value: aws.String("val2")},
 		}).Return(nil)
-	mockAWSClient.EXPECT().GetSnapshots(context.TODO(), "ami-1234567890abcdef0").Return(
-		[]string{"snap-1234567890abcdef0", "snap-1234567890abcdef1", "snap-1234567890abcdef2"},
-		nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+			{DeviceName: aws.String("/dev/sdf"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef1"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+			{DeviceName: aws.String("/dev/sdg"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef2"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+		},
+	}, nil)
 	createSnapTag1 := mockAWSClient.EXPECT().CreateTags(
 		context.TODO(),
 		"snap-1234567890abcdef0",
@@ -117,8 +188,14 @@ func TestCreate_InstanceNameTagValue_MultiByte(t *testing.T) {
 			{Key: aws.String("BackupType"), Value: aws.String("auto")},
 			{Key: aws.String("Name"), Value: aws.String("テストサーバ")},
 			{Key: aws.String("Service"), Value: aws.String("service")},
+			{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
 			{Key: aws.String("key1"), Value: aws.String("val1")},
 			{Key: aws.String("key2"), Value: aws.String("val2")},
+			{Key: aws.String("DeviceName"), Value: aws.String("/dev/xvda")},
+			{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef0")},
+			{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+			{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+			{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
 		}).Return(nil).After(createAMITag)
 	createSnapTag2 := mockAWSClient.EXPECT().CreateTags(
 		context.TODO(),
@@ -127,8 +204,14 @@ func TestCreate_InstanceNameTagValue_MultiByte(t *testing.T) {
 			{Key: aws.String("BackupType"), Value: aws.String("auto")},
 			{Key: aws.String("Name"), Value: aws.String("テストサーバ")},
 			{Key: aws.String("Service"), Value: aws.String("service")},
+			{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
 			{Key: aws.String("key1"), Value: aws.String("val1")},
 			{Key: aws.String("key2"), Value: aws.String("val2")},
+			{Key: aws.String("DeviceName"), Value: aws.String("/dev/sdf")},
+			{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef1")},
+			{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+			{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+			{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
 		}).Return(nil).After(createSnapTag1)
 	mockAWSClient.EXPECT().CreateTags(
 		context.TODO(),
@@ -137,8 +220,14 @@ func TestCreate_InstanceNameTagValue_MultiByte(t *testing.T) {
 			{Key: aws.String("BackupType"), Value: aws.String("auto")},
 			{Key: aws.String("Name"), Value: aws.String("テストサーバ")},
 			{Key: aws.String("Service"), Value: aws.String("service")},
+			{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
 			{Key: aws.String("key1"), Value: aws.String("val1")},
 			{Key: aws.String("key2"), Value: aws.String("val2")},
+			{Key: aws.String("DeviceName"), Value: aws.String("/dev/sdg")},
+			{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef2")},
+			{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+			{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+			{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
 		}).Return(nil).After(createSnapTag2)
 
 	backup := &Backup{
@@ -163,18 +252,290 @@ func TestCreate_InstanceNameTagValue_MultiByte(t *testing.T) {
 	}
 }
 
+func TestCreate_ExcludeVolumes(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tag := []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+	}
+
+	attachment := func(device string) []*ec2.VolumeAttachment {
+		return []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String(device)}}
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Volume{
+		{VolumeId: aws.String("vol-1234567890abcdef0"), Attachments: attachment("/dev/xvda")},
+		{VolumeId: aws.String("vol-1234567890abcdef1"), Attachments: attachment("/dev/sdf")},
+		{VolumeId: aws.String("vol-1234567890abcdef2"), Attachments: attachment("/dev/sdg")},
+		{
+			VolumeId:    aws.String("vol-1234567890abcdef3"),
+			Attachments: attachment("/dev/sdh"),
+			Tags:        []*ec2.Tag{{Key: aws.String("Backup"), Value: aws.String("false")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().ImageNameExists(context.TODO(), imageNameMatcher("test")).Return(false, nil)
+	mockAWSClient.EXPECT().CreateImage(
+		context.TODO(),
+		"i-1234567890abcdef0",
+		imageNameMatcher("test"),
+		"create by go-create-image-backup",
+		[]string{"/dev/sdf", "/dev/sdg", "/dev/sdh"}).Return("ami-1234567890abcdef0", nil)
+	createAMITag := mockAWSClient.EXPECT().CreateTags(context.TODO(), "ami-1234567890abcdef0", tag).Return(nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "snap-1234567890abcdef0", append(tag,
+		&ec2.Tag{Key: aws.String("DeviceName"), Value: aws.String("/dev/xvda")},
+		&ec2.Tag{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef0")},
+		&ec2.Tag{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+		&ec2.Tag{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+		&ec2.Tag{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
+	)).Return(nil).After(createAMITag)
+
+	backup := &Backup{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "test",
+		Service:    "service",
+		Exclude: ExcludeVolumes{
+			Devices:   []string{"/dev/sdf"},
+			VolumeIDs: []string{"vol-1234567890abcdef2"},
+			Tags:      []Tag{{Key: "Backup", Value: "false"}},
+		},
+		Client: mockAWSClient,
+	}
+
+	got, err := backup.Create(context.TODO())
+	if err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+
+	want := "ami-1234567890abcdef0"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetRegion().Return("ap-northeast-1", nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{
+		ImageId:     aws.String("ami-1234567890abcdef0"),
+		Name:        aws.String("test-200601021504"),
+		Description: aws.String("create by go-create-image-backup"),
+	}, nil)
+
+	tag := []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+	}
+
+	mockDstAWSClient := mock.NewMockAWS(mockCtrl)
+	mockDstAWSClient.EXPECT().CopyImage(
+		context.TODO(),
+		"ap-northeast-1",
+		"ami-1234567890abcdef0",
+		"test-200601021504",
+		"create by go-create-image-backup",
+		"").Return("ami-0987654321abcdef0", nil)
+	createAMITag := mockDstAWSClient.EXPECT().CreateTags(context.TODO(), "ami-0987654321abcdef0", tag).Return(nil)
+	mockDstAWSClient.EXPECT().GetImage(context.TODO(), "ami-0987654321abcdef0").Return(&ec2.Image{
+		ImageId: aws.String("ami-0987654321abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-0987654321abcdef0"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+		},
+	}, nil)
+	mockDstAWSClient.EXPECT().CreateTags(context.TODO(), "snap-0987654321abcdef0", append(tag,
+		&ec2.Tag{Key: aws.String("DeviceName"), Value: aws.String("/dev/xvda")},
+		&ec2.Tag{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+		&ec2.Tag{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+		&ec2.Tag{Key: aws.String("ImageId"), Value: aws.String("ami-0987654321abcdef0")},
+	)).Return(nil).After(createAMITag)
+
+	backup := &Backup{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "test",
+		Service:    "service",
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.Copy(context.TODO(), "ami-1234567890abcdef0", &Backup{Client: mockDstAWSClient})
+	if err != nil {
+		t.Fatal("Copy failed: ", err)
+	}
+
+	want := "ami-0987654321abcdef0"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCopy_BackupInterval(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetRegion().Return("ap-northeast-1", nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{
+		ImageId:         aws.String("ami-1234567890abcdef0"),
+		Name:            aws.String("test-200601021504"),
+		Description:     aws.String("create by go-create-image-backup"),
+		DeprecationTime: aws.String("2006-01-09T15:04:00.000Z"),
+	}, nil)
+
+	mockDstAWSClient := mock.NewMockAWS(mockCtrl)
+	mockDstAWSClient.EXPECT().CopyImage(
+		context.TODO(),
+		"ap-northeast-1",
+		"ami-1234567890abcdef0",
+		"test-200601021504",
+		"create by go-create-image-backup",
+		"").Return("ami-0987654321abcdef0", nil)
+	mockDstAWSClient.EXPECT().CreateTags(context.TODO(), "ami-0987654321abcdef0", gomock.Any()).Return(nil)
+	mockDstAWSClient.EXPECT().GetImage(context.TODO(), "ami-0987654321abcdef0").Return(&ec2.Image{
+		ImageId: aws.String("ami-0987654321abcdef0"),
+	}, nil)
+
+	// the copy is deprecated by the generation of the destination instead of the deprecation time of the source
+	var deprecateAt time.Time
+	mockDstAWSClient.EXPECT().DeprecateImage(context.TODO(), "ami-0987654321abcdef0", gomock.Any()).Do(func(ctx context.Context, imageID string, t time.Time) {
+		deprecateAt = t
+	}).Return(nil)
+
+	backup := &Backup{
+		InstanceID:     "i-1234567890abcdef0",
+		Name:           "test",
+		Service:        "service",
+		Generation:     7,
+		BackupInterval: 24 * time.Hour,
+		Client:         mockAWSClient,
+	}
+	dst := *backup
+	dst.Generation = 30
+	dst.Client = mockDstAWSClient
+
+	if _, err := backup.Copy(context.TODO(), "ami-1234567890abcdef0", &dst); err != nil {
+		t.Fatal("Copy failed: ", err)
+	}
+
+	want := time.Now().Add(30 * 24 * time.Hour)
+	if deprecateAt.After(want) || deprecateAt.Before(want.Add(-time.Minute)) {
+		t.Fatalf("got %s, want %s", deprecateAt, want)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tag := []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+		{Key: aws.String("SourceImageId"), Value: aws.String("ami-1234567890abcdef0")},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetRegion().Return("ap-northeast-1", nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{
+		ImageId:     aws.String("ami-1234567890abcdef0"),
+		Name:        aws.String("test-200601021504"),
+		Description: aws.String("create by go-create-image-backup"),
+	}, nil)
+	mockAWSClient.EXPECT().CopyImage(
+		context.TODO(),
+		"ap-northeast-1",
+		"ami-1234567890abcdef0",
+		"test-200601021504-encrypted",
+		"create by go-create-image-backup",
+		"alias/backup").Return("ami-0987654321abcdef0", nil)
+	createAMITag := mockAWSClient.EXPECT().CreateTags(context.TODO(), "ami-0987654321abcdef0", tag).Return(nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-0987654321abcdef0").Return(&ec2.Image{
+		ImageId: aws.String("ami-0987654321abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-0987654321abcdef0"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "snap-0987654321abcdef0", append(tag,
+		&ec2.Tag{Key: aws.String("DeviceName"), Value: aws.String("/dev/xvda")},
+		&ec2.Tag{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+		&ec2.Tag{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+		&ec2.Tag{Key: aws.String("ImageId"), Value: aws.String("ami-0987654321abcdef0")},
+	)).Return(nil).After(createAMITag)
+
+	backup := &Backup{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "test",
+		Service:    "service",
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.Encrypt(context.TODO(), "ami-1234567890abcdef0", mockAWSClient, "alias/backup")
+	if err != nil {
+		t.Fatal("Encrypt failed: ", err)
+	}
+
+	want := "ami-0987654321abcdef0"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestEncryptedImageName(t *testing.T) {
+	var cases = []struct {
+		name string
+		want string
+	}{
+		{
+			name: "test-200601021504",
+			want: "test-200601021504-encrypted",
+		},
+		{
+			name: strings.Repeat("a", 118),
+			want: strings.Repeat("a", 118) + "-encrypted",
+		},
+		{
+			name: strings.Repeat("a", 128),
+			want: strings.Repeat("a", 118) + "-encrypted",
+		},
+	}
+
+	for _, c := range cases {
+		got := encryptedImageName(c.name)
+		if got != c.want {
+			t.Errorf("got %s, want %s", got, c.want)
+		}
+		if err := validateImageName(got); err != nil {
+			t.Error(err)
+		}
+	}
+}
 func TestRotate(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	mockAWSClient := mock.NewMockAWS(mockCtrl)
-	mockAWSClient.EXPECT().GetImages(context.TODO(), "test", "service").Return([]*ec2.Image{
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T17:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef3"), CreationDate: aws.String("2006-01-02T18:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef4"), CreationDate: aws.String("2006-01-02T19:04:05.000Z"), State: aws.String("available")},
 	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
 	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
@@ -198,18 +559,58 @@ func TestRotate(t *testing.T) {
 	}
 }
 
+func TestRotate_GroupByInstanceID(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	images := []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+	}
+
+	// backups of the instance are rotated together even if the Name tag of the instance is changed
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+		{Key: aws.String("Service"), Value: aws.String("daily")},
+	}).Return(images, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), images[:1]).Return(nil)
+
+	backup := &Backup{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "renamed",
+		Service:    "daily",
+		Generation: 1,
+		GroupBy:    InstanceIDGroupBy,
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.Rotate(context.TODO(), "ami-1234567890abcdef1")
+	if err != nil {
+		t.Fatal("Rotate failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
 func TestRotate_InstanceNameTagValue_MultiByte(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
 	mockAWSClient := mock.NewMockAWS(mockCtrl)
-	mockAWSClient.EXPECT().GetImages(context.TODO(), "テストサーバ", "service").Return([]*ec2.Image{
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("テストサーバ", "service")).Return([]*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T17:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef3"), CreationDate: aws.String("2006-01-02T18:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef4"), CreationDate: aws.String("2006-01-02T19:04:05.000Z"), State: aws.String("available")},
 	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
 	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
@@ -238,7 +639,7 @@ func TestRotate_NotRun(t *testing.T) {
 	defer mockCtrl.Finish()
 
 	mockAWSClient := mock.NewMockAWS(mockCtrl)
-	mockAWSClient.EXPECT().GetImages(context.TODO(), "test", "service").Return([]*ec2.Image{
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T17:04:05.000Z"), State: aws.String("available")},
@@ -269,7 +670,7 @@ func TestRotate_RecentlyImageID_NotFound(t *testing.T) {
 	defer mockCtrl.Finish()
 
 	mockAWSClient := mock.NewMockAWS(mockCtrl)
-	mockAWSClient.EXPECT().GetImages(context.TODO(), "test", "service").Return([]*ec2.Image{
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T17:04:05.000Z"), State: aws.String("available")},
@@ -278,6 +679,7 @@ func TestRotate_RecentlyImageID_NotFound(t *testing.T) {
 	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef4").Return(&ec2.Image{
 		ImageId: aws.String("ami-1234567890abcdef4"), CreationDate: aws.String("2006-01-02T19:04:05.000Z"), State: aws.String("available"),
 	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
 	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
@@ -306,13 +708,14 @@ func TestRotate_FailedImage_Found(t *testing.T) {
 	defer mockCtrl.Finish()
 
 	mockAWSClient := mock.NewMockAWS(mockCtrl)
-	mockAWSClient.EXPECT().GetImages(context.TODO(), "test", "service").Return([]*ec2.Image{
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T17:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef3"), CreationDate: aws.String("2006-01-02T18:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef4"), CreationDate: aws.String("2006-01-02T19:04:05.000Z"), State: aws.String("failed")},
 	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
 	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef4"), CreationDate: aws.String("1970-01-01T00:00:00.000Z"), State: aws.String("failed")},
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
@@ -341,13 +744,14 @@ func TestRotate_RecentlyImageID_Pending(t *testing.T) {
 	defer mockCtrl.Finish()
 
 	mockAWSClient := mock.NewMockAWS(mockCtrl)
-	mockAWSClient.EXPECT().GetImages(context.TODO(), "test", "service").Return([]*ec2.Image{
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T17:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef3"), CreationDate: aws.String("2006-01-02T18:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef4"), State: aws.String("pending")},
 	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
 	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
 		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
 		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
@@ -370,3 +774,734 @@ func TestRotate_RecentlyImageID_Pending(t *testing.T) {
 		t.Fatalf("got %s, want %s", got, want)
 	}
 }
+
+func TestRotate_Unshare(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+	}, nil)
+	// images shared by former runs are unshared even if ShareWith is empty
+	unshare := mockAWSClient.EXPECT().UnshareImage(context.TODO(),
+		&ec2.Image{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+	).Return(nil)
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+	}).Return(nil).After(unshare)
+
+	backup := &Backup{
+		Name:       "test",
+		Service:    "service",
+		Generation: 1,
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.Rotate(context.TODO(), "ami-1234567890abcdef1")
+	if err != nil {
+		t.Fatal("Rotate failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotate_EncryptedImage_Found(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	encryptedTag := func(sourceImageID string) []*ec2.Tag {
+		return []*ec2.Tag{{Key: aws.String("SourceImageId"), Value: aws.String(sourceImageID)}}
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-0987654321abcdef0"), CreationDate: aws.String("2006-01-02T15:14:05.000Z"), State: aws.String("available"), Tags: encryptedTag("ami-1234567890abcdef0")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-0987654321abcdef1"), CreationDate: aws.String("2006-01-02T16:14:05.000Z"), State: aws.String("available"), Tags: encryptedTag("ami-1234567890abcdef1")},
+		{ImageId: aws.String("ami-0987654321abcdef2"), CreationDate: aws.String("2006-01-02T17:14:05.000Z"), State: aws.String("available"), Tags: encryptedTag("ami-1234567890abcdef2")},
+	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-0987654321abcdef0"), CreationDate: aws.String("2006-01-02T15:14:05.000Z"), State: aws.String("available"), Tags: encryptedTag("ami-1234567890abcdef0")},
+	}).Return(nil)
+
+	backup := &Backup{
+		Name:       "test",
+		Service:    "service",
+		Generation: 2,
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.Rotate(context.TODO(), "ami-0987654321abcdef2")
+	if err != nil {
+		t.Fatal("Rotate failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0", "ami-0987654321abcdef0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	archivedTag := []*ec2.Tag{{Key: aws.String("BackupTier"), Value: aws.String("archive")}}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T14:04:05.000Z"), State: aws.String("available"), Tags: archivedTag},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+	}, nil)
+	mockAWSClient.EXPECT().ArchiveImage(context.TODO(),
+		&ec2.Image{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		archivedTag,
+	).Return(nil)
+
+	backup := &Backup{
+		Name:              "test",
+		Service:           "service",
+		Generation:        1,
+		ArchiveGeneration: 2,
+		Client:            mockAWSClient,
+	}
+
+	got, err := backup.Archive(context.TODO(), "ami-1234567890abcdef2")
+	if err != nil {
+		t.Fatal("Archive failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotate_ArchivedImage_Found(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	archivedTag := []*ec2.Tag{{Key: aws.String("BackupTier"), Value: aws.String("archive")}}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T13:04:05.000Z"), State: aws.String("available"), Tags: archivedTag},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T14:04:05.000Z"), State: aws.String("available"), Tags: archivedTag},
+		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available"), Tags: archivedTag},
+		{ImageId: aws.String("ami-1234567890abcdef3"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T13:04:05.000Z"), State: aws.String("available"), Tags: archivedTag},
+	}).Return(nil)
+
+	backup := &Backup{
+		Name:              "test",
+		Service:           "service",
+		Generation:        1,
+		ArchiveGeneration: 2,
+		Client:            mockAWSClient,
+	}
+
+	got, err := backup.Rotate(context.TODO(), "ami-1234567890abcdef3")
+	if err != nil {
+		t.Fatal("Rotate failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCreate_DeprecateAfter(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tag := []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().ImageNameExists(context.TODO(), imageNameMatcher("test")).Return(false, nil)
+	mockAWSClient.EXPECT().GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Volume{
+		{VolumeId: aws.String("vol-1234567890abcdef0"), Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/xvda")}}},
+	}, nil)
+	mockAWSClient.EXPECT().CreateImage(context.TODO(), "i-1234567890abcdef0", imageNameMatcher("test"), "create by go-create-image-backup", gomock.Nil()).Return("ami-1234567890abcdef0", nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "ami-1234567890abcdef0", tag).Return(nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0"), VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "snap-1234567890abcdef0", append(tag,
+		&ec2.Tag{Key: aws.String("DeviceName"), Value: aws.String("/dev/xvda")},
+		&ec2.Tag{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef0")},
+		&ec2.Tag{Key: aws.String("VolumeType"), Value: aws.String("gp2")},
+		&ec2.Tag{Key: aws.String("VolumeSize"), Value: aws.String("8")},
+		&ec2.Tag{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
+	)).Return(nil)
+
+	var deprecateAt time.Time
+	mockAWSClient.EXPECT().DeprecateImage(context.TODO(), "ami-1234567890abcdef0", gomock.Any()).Do(func(ctx context.Context, imageID string, t time.Time) {
+		deprecateAt = t
+	}).Return(nil)
+
+	backup := &Backup{
+		InstanceID:     "i-1234567890abcdef0",
+		Name:           "test",
+		Service:        "service",
+		DeprecateAfter: 30 * 24 * time.Hour,
+		Client:         mockAWSClient,
+	}
+
+	if _, err := backup.Create(context.TODO()); err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+
+	want := time.Now().Add(30 * 24 * time.Hour)
+	if deprecateAt.After(want) || deprecateAt.Before(want.Add(-time.Minute)) {
+		t.Fatalf("got %s, want %s", deprecateAt, want)
+	}
+}
+
+func TestRotate_RotateDeprecated(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available"), DeprecationTime: aws.String("2006-01-03T15:04:05.000Z")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available"), DeprecationTime: aws.String("2006-01-03T16:04:05.000Z")},
+		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T17:04:05.000Z"), State: aws.String("available"), DeprecationTime: aws.String("2999-01-03T17:04:05.000Z")},
+		{ImageId: aws.String("ami-1234567890abcdef3"), CreationDate: aws.String("2006-01-02T18:04:05.000Z"), State: aws.String("available")},
+	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available"), DeprecationTime: aws.String("2006-01-03T15:04:05.000Z")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available"), DeprecationTime: aws.String("2006-01-03T16:04:05.000Z")},
+	}).Return(nil)
+
+	backup := &Backup{
+		Name:             "test",
+		Service:          "service",
+		Generation:       3,
+		RotateDeprecated: true,
+		Client:           mockAWSClient,
+	}
+
+	got, err := backup.Rotate(context.TODO(), "ami-1234567890abcdef3")
+	if err != nil {
+		t.Fatal("Rotate failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0", "ami-1234567890abcdef1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotate_Retention(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2020-01-27T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2020-02-03T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2020-02-09T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef3"), CreationDate: aws.String("2020-02-10T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef4"), CreationDate: aws.String("2020-02-11T04:00:00.000Z"), State: aws.String("available")},
+	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2020-01-27T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2020-02-03T04:00:00.000Z"), State: aws.String("available")},
+	}).Return(nil)
+
+	backup := &Backup{
+		Name:       "test",
+		Service:    "service",
+		Generation: 10,
+		Retention:  RetentionPolicy{Daily: 2, Weekly: 2},
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.Rotate(context.TODO(), "ami-1234567890abcdef4")
+	if err != nil {
+		t.Fatal("Rotate failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0", "ami-1234567890abcdef1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotate_MaxAge(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	const layout = "2006-01-02T15:04:05.000Z"
+	daysAgo := func(days int) *string {
+		return aws.String(time.Now().UTC().AddDate(0, 0, -days).Format(layout))
+	}
+	images := []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: daysAgo(40), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: daysAgo(35), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: daysAgo(32), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef3"), CreationDate: daysAgo(10), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef4"), CreationDate: daysAgo(0), State: aws.String("available")},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return(images, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), images[:2]).Return(nil)
+
+	backup := &Backup{
+		Name:           "test",
+		Service:        "service",
+		Generation:     1,
+		MaxAge:         30 * 24 * time.Hour,
+		MinGenerations: 3,
+		Client:         mockAWSClient,
+	}
+
+	got, err := backup.Rotate(context.TODO(), "ami-1234567890abcdef4")
+	if err != nil {
+		t.Fatal("Rotate failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0", "ami-1234567890abcdef1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotate_PartialFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	images := []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T17:04:05.000Z"), State: aws.String("available")},
+	}
+	deregisterErr := &deregisterError{
+		failures: []resourceError{{resourceID: "ami-1234567890abcdef0", err: errors.New("UnauthorizedOperation")}},
+		total:    2,
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return(images, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), images[:2]).Return(deregisterErr)
+
+	backup := &Backup{
+		Name:       "test",
+		Service:    "service",
+		Generation: 1,
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.Rotate(context.TODO(), "ami-1234567890abcdef2")
+	if err != deregisterErr {
+		t.Fatalf("got %v, want %v", err, deregisterErr)
+	}
+
+	want := []string{"ami-1234567890abcdef1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCreate_NameTemplate(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	tag := []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+		{Key: aws.String("Env"), Value: aws.String("prod")},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	exists := mockAWSClient.EXPECT().ImageNameExists(context.TODO(), "prod-test-i-1234567890abcdef0-1").Return(true, nil)
+	mockAWSClient.EXPECT().ImageNameExists(context.TODO(), "prod-test-i-1234567890abcdef0-2").Return(false, nil).After(exists)
+	mockAWSClient.EXPECT().GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Volume{}, nil)
+	mockAWSClient.EXPECT().CreateImage(
+		context.TODO(),
+		"i-1234567890abcdef0",
+		"prod-test-i-1234567890abcdef0-2",
+		"backup of test for service",
+		gomock.Nil()).Return("ami-1234567890abcdef0", nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "ami-1234567890abcdef0", tag).Return(nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{ImageId: aws.String("ami-1234567890abcdef0")}, nil)
+
+	backup := &Backup{
+		InstanceID:          "i-1234567890abcdef0",
+		Name:                "test",
+		Service:             "service",
+		CustomTags:          []Tag{{Key: "Env", Value: "prod"}},
+		NameTemplate:        template.Must(ParseTemplate("name", "{{.Tags.Env}}-{{.Name}}-{{.InstanceID}}-{{.Sequence}}")),
+		DescriptionTemplate: template.Must(ParseTemplate("description", "backup of {{.Name}} for {{.Service}}")),
+		Client:              mockAWSClient,
+	}
+
+	got, err := backup.Create(context.TODO())
+	if err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+
+	want := "ami-1234567890abcdef0"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestCreate_NameTemplate_Collision(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().ImageNameExists(context.TODO(), "test-200601021504").Return(true, nil)
+
+	backup := &Backup{
+		InstanceID:   "i-1234567890abcdef0",
+		Name:         "test",
+		Service:      "service",
+		NameTemplate: template.Must(ParseTemplate("name", "{{.Name}}-200601021504")),
+		Client:       mockAWSClient,
+	}
+
+	_, err := backup.Create(context.TODO())
+
+	want := "image name already exists: test-200601021504"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %s", err, want)
+	}
+}
+
+func TestCreate_PropagateTags(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().ImageNameExists(context.TODO(), imageNameMatcher("test")).Return(false, nil)
+	mockAWSClient.EXPECT().CreateImage(context.TODO(), "i-1234567890abcdef0", imageNameMatcher("test"), "create by go-create-image-backup", gomock.Nil()).Return("ami-1234567890abcdef0", nil)
+	mockAWSClient.EXPECT().GetInstanceTags(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Tag{
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("CostCenter"), Value: aws.String("1234")},
+		{Key: aws.String("team:owner"), Value: aws.String("infra")},
+		{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("stack")},
+		{Key: aws.String("Role"), Value: aws.String("web")},
+	}, nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "ami-1234567890abcdef0", []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+		{Key: aws.String("CostCenter"), Value: aws.String("1234")},
+		{Key: aws.String("team:owner"), Value: aws.String("infra")},
+	}).Return(nil)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/sda1"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")}},
+			{DeviceName: aws.String("/dev/sdf"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef1")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Volume{
+		{
+			VolumeId:    aws.String("vol-1234567890abcdef0"),
+			Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/sda1")}},
+		},
+		{
+			VolumeId:    aws.String("vol-1234567890abcdef1"),
+			Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/sdf")}},
+			Tags:        []*ec2.Tag{{Key: aws.String("CostCenter"), Value: aws.String("5678")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "snap-1234567890abcdef0", []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+		{Key: aws.String("DeviceName"), Value: aws.String("/dev/sda1")},
+		{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef0")},
+		{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
+	}).Return(nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "snap-1234567890abcdef1", []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+		{Key: aws.String("DeviceName"), Value: aws.String("/dev/sdf")},
+		{Key: aws.String("VolumeId"), Value: aws.String("vol-1234567890abcdef1")},
+		{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
+		{Key: aws.String("CostCenter"), Value: aws.String("5678")},
+	}).Return(nil)
+
+	backup := &Backup{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "test",
+		Service:    "service",
+		PropagateTags: TagFilter{
+			Keys:    []string{"CostCenter", "Name"},
+			Pattern: regexp.MustCompile(`^(team|aws):`),
+		},
+		Client: mockAWSClient,
+	}
+
+	got, err := backup.Create(context.TODO())
+	if err != nil {
+		t.Fatal("Create failed: ", err)
+	}
+
+	want := "ami-1234567890abcdef0"
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotate_TagSchema(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	encryptedTag := []*ec2.Tag{{Key: aws.String("backup:SourceImageId"), Value: aws.String("ami-1234567890abcdef0")}}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), []*ec2.Tag{
+		{Key: aws.String("backup:BackupType"), Value: aws.String("go-create-image-backup")},
+		{Key: aws.String("backup:Name"), Value: aws.String("test")},
+		{Key: aws.String("backup:BackupService"), Value: aws.String("service")},
+	}).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-0987654321abcdef0"), CreationDate: aws.String("2006-01-02T15:14:05.000Z"), State: aws.String("available"), Tags: encryptedTag},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+	}, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-0987654321abcdef0"), CreationDate: aws.String("2006-01-02T15:14:05.000Z"), State: aws.String("available"), Tags: encryptedTag},
+	}).Return(nil)
+
+	backup := &Backup{
+		Name:       "test",
+		Service:    "service",
+		Generation: 1,
+		Schema: TagSchema{
+			Prefix:          "backup:",
+			Keys:            map[string]string{TagService: "BackupService"},
+			BackupTypeValue: "go-create-image-backup",
+		},
+		Client: mockAWSClient,
+	}
+
+	got, err := backup.Rotate(context.TODO(), "ami-1234567890abcdef1")
+	if err != nil {
+		t.Fatal("Rotate failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0", "ami-0987654321abcdef0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotate_GroupBy(t *testing.T) {
+	var cases = []struct {
+		groupBy []string
+		tags    []*ec2.Tag
+	}{
+		{
+			groupBy: []string{"Service", "Environment"},
+			tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("Service"), Value: aws.String("service")},
+				{Key: aws.String("Environment"), Value: aws.String("prod")},
+			},
+		},
+		{
+			groupBy: []string{"InstanceId"},
+			tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(strings.Join(c.groupBy, ","), func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			mockAWSClient := mock.NewMockAWS(mockCtrl)
+			mockAWSClient.EXPECT().GetImages(context.TODO(), c.tags).Return([]*ec2.Image{
+				{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+				{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+			}, nil)
+			mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+			mockAWSClient.EXPECT().DeregisterImages(context.TODO(), []*ec2.Image{
+				{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+			}).Return(nil)
+
+			backup := &Backup{
+				InstanceID: "i-1234567890abcdef0",
+				Name:       "test",
+				Service:    "service",
+				Generation: 1,
+				CustomTags: []Tag{{Key: "Environment", Value: "prod"}},
+				GroupBy:    c.groupBy,
+				Client:     mockAWSClient,
+			}
+
+			got, err := backup.Rotate(context.TODO(), "ami-1234567890abcdef1")
+			if err != nil {
+				t.Fatal("Rotate failed: ", err)
+			}
+
+			want := []string{"ami-1234567890abcdef0"}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateSnapshotSet(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().CreateSnapshots(
+		context.TODO(),
+		"i-1234567890abcdef0",
+		gomock.Nil(),
+		gomock.Any()).Do(func(ctx context.Context, instanceID string, excludeVolumeIDs []string, tags []*ec2.Tag) {
+		if len(tags) != 5 || *tags[4].Key != "BackupSetId" || !strings.HasPrefix(*tags[4].Value, "i-1234567890abcdef0-") {
+			t.Fatalf("unexpected tags: %s", tags)
+		}
+	}).Return([]string{"snap-1234567890abcdef0", "snap-1234567890abcdef1"}, nil)
+
+	backup := &Backup{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "test",
+		Service:    "service",
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.CreateSnapshotSet(context.TODO())
+	if err != nil {
+		t.Fatal("CreateSnapshotSet failed: ", err)
+	}
+
+	if !strings.HasPrefix(got, "i-1234567890abcdef0-") {
+		t.Fatalf("got %s, want i-1234567890abcdef0-<timestamp>", got)
+	}
+}
+
+func TestRotateSnapshotSets(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	snapshot := func(snapshotID, setID string, startTime time.Time) *ec2.Snapshot {
+		return &ec2.Snapshot{
+			SnapshotId: aws.String(snapshotID),
+			StartTime:  aws.Time(startTime),
+			Tags:       []*ec2.Tag{{Key: aws.String("BackupSetId"), Value: aws.String(setID)}},
+		}
+	}
+	base := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), groupTags("test", "service"), []string{"BackupSetId"}).Return([]*ec2.Snapshot{
+		snapshot("snap-1234567890abcdef0", "set0", base),
+		snapshot("snap-1234567890abcdef1", "set0", base.Add(time.Second)),
+		snapshot("snap-1234567890abcdef2", "set1", base.Add(time.Hour)),
+		snapshot("snap-1234567890abcdef3", "set1", base.Add(time.Hour)),
+		snapshot("snap-1234567890abcdef4", "set2", base.Add(2*time.Hour)),
+	}, nil)
+	mockAWSClient.EXPECT().DeleteSnapshots(context.TODO(), []string{"snap-1234567890abcdef0", "snap-1234567890abcdef1"}).Return(nil)
+
+	backup := &Backup{
+		Name:       "test",
+		Service:    "service",
+		Generation: 2,
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.RotateSnapshotSets(context.TODO(), "set2")
+	if err != nil {
+		t.Fatal("RotateSnapshotSets failed: ", err)
+	}
+
+	want := []string{"set0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotateSnapshotSets_PartialFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	snapshot := func(snapshotID, setID string, startTime time.Time) *ec2.Snapshot {
+		return &ec2.Snapshot{
+			SnapshotId: aws.String(snapshotID),
+			StartTime:  aws.Time(startTime),
+			Tags:       []*ec2.Tag{{Key: aws.String("BackupSetId"), Value: aws.String(setID)}},
+		}
+	}
+	base := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), groupTags("test", "service"), []string{"BackupSetId"}).Return([]*ec2.Snapshot{
+		snapshot("snap-1234567890abcdef0", "set0", base),
+		snapshot("snap-1234567890abcdef1", "set1", base.Add(time.Hour)),
+		snapshot("snap-1234567890abcdef2", "set2", base.Add(2*time.Hour)),
+	}, nil)
+	mockAWSClient.EXPECT().DeleteSnapshots(context.TODO(), []string{"snap-1234567890abcdef0"}).Return(&deleteSnapshotsError{
+		failures: []resourceError{{resourceID: "snap-1234567890abcdef0", err: errors.New("unauthorized")}},
+		total:    1,
+	})
+	mockAWSClient.EXPECT().DeleteSnapshots(context.TODO(), []string{"snap-1234567890abcdef1"}).Return(nil)
+
+	backup := &Backup{
+		Name:       "test",
+		Service:    "service",
+		Generation: 1,
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.RotateSnapshotSets(context.TODO(), "set2")
+	if err == nil {
+		t.Fatal("RotateSnapshotSets should fail")
+	}
+
+	// the backup set which is deleted is returned with the error
+	want := []string{"set1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	wantErr := "failed to delete 1 of 2 snapshot sets: set0: failed to delete 1 of 1 snapshots: snap-1234567890abcdef0: unauthorized"
+	if err.Error() != wantErr {
+		t.Fatalf("got %s, want %s", err.Error(), wantErr)
+	}
+}
@@ -5,8 +5,15 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/pkg/errors"
 )
 
@@ -20,23 +27,79 @@ const (
 // CLI is the command line object.
 type CLI struct {
 	// outStream and errStream are the stdout and stderr
-	// to write message from the CLI.
+	// to write message from the CLI, and inStream is the stdin to read confirmation.
 	outStream, errStream io.Writer
+	inStream             io.Reader
 	flags                cliFlags
 	mail                 MailClient
+	// command is the subcommand, empty means creating a backup and rotating old backups.
+	command string
 }
 
+// Subcommands which share flags of backup.
+const (
+	commandCreate = "create"
+	commandRotate = "rotate"
+	commandPrune  = "prune"
+	commandList   = "list"
+)
+
+// Subcommands which have their own flags.
+const (
+	commandMigrateTags   = "migrate-tags"
+	commandAdopt         = "adopt"
+	commandGC            = "gc"
+	commandRestore       = "restore"
+	commandRestoreVolume = "restore-volume"
+)
+
 type cliFlags struct {
-	instanceID string
-	generation int
-	region     string
-	service    string
-	customTags []Tag
-	version    bool
-	to         string
-	from       string
-	server     string
-	port       int
+	instanceID            string
+	instanceFilters       []*ec2.Filter
+	concurrency           int
+	instanceTimeout       time.Duration
+	generation            int
+	archiveGeneration     int
+	region                string
+	service               string
+	customTags            []Tag
+	copyDestinations      []copyDestination
+	shareWith             []string
+	kmsKeyID              string
+	encryptRegion         string
+	deregisterUnencrypted bool
+	excludeDevices        []string
+	excludeVolumeIDs      []string
+	excludeVolumeTags     []Tag
+	snapshotOnly          bool
+	deprecateAfter        time.Duration
+	backupInterval        time.Duration
+	rotateDeprecated      bool
+	nameTemplate          *template.Template
+	propagateTags         []string
+	tagSchema             tagSchemaFlags
+	groupBy               []string
+	rotateByInstanceID    bool
+	keepDaily             int
+	keepWeekly            int
+	keepMonthly           int
+	keepYearly            int
+	maxAge                time.Duration
+	minGenerations        int
+	dryRun                bool
+	config                string
+	name                  string
+	all                   bool
+	since                 time.Time
+	until                 time.Time
+	output                string
+	propagateTagsRegex    *regexp.Regexp
+	descriptionTemplate   *template.Template
+	version               bool
+	to                    string
+	from                  string
+	server                string
+	port                  int
 }
 
 type tagSliceValue []Tag
@@ -83,20 +146,428 @@ func newTagSliceValue(val string, p *[]Tag) *tagSliceValue {
 	return (*tagSliceValue)(p)
 }
 
+type stringSliceValue []string
+
+func (s *stringSliceValue) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceValue) Set(val string) error {
+	vals := strings.Split(val, ",")
+	for _, v := range vals {
+		if v == "" {
+			return errors.New("parse error")
+		}
+	}
+
+	*s = stringSliceValue(vals)
+
+	return nil
+}
+
+// durationValue is a time.Duration which also accepts number of days like 30d.
+type durationValue time.Duration
+
+func (d *durationValue) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *durationValue) Set(val string) error {
+	if strings.HasSuffix(val, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(val, "d"))
+		if err != nil || days < 0 {
+			return errors.New("parse error")
+		}
+		*d = durationValue(time.Duration(days) * 24 * time.Hour)
+		return nil
+	}
+
+	v, err := time.ParseDuration(val)
+	if err != nil || v < 0 {
+		return errors.New("parse error")
+	}
+	*d = durationValue(v)
+
+	return nil
+}
+
+// timeValue is a time.Time which accepts RFC 3339 time like 2006-01-02T15:04:05Z or date like 2006-01-02 in UTC.
+type timeValue time.Time
+
+func (t *timeValue) String() string {
+	if time.Time(*t).IsZero() {
+		return ""
+	}
+	return time.Time(*t).Format(time.RFC3339)
+}
+
+func (t *timeValue) Set(val string) error {
+	v, err := parseTime(val)
+	if err != nil {
+		return errors.New("parse error")
+	}
+	*t = timeValue(v)
+
+	return nil
+}
+
+func parseTime(val string) (time.Time, error) {
+	if v, err := time.Parse("2006-01-02", val); err == nil {
+		return v, nil
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
+// templateValue is a template of machine image name or description.
+type templateValue struct {
+	name string
+	tmpl **template.Template
+}
+
+func (t *templateValue) String() string {
+	if t.tmpl == nil || *t.tmpl == nil {
+		return ""
+	}
+	return (*t.tmpl).Root.String()
+}
+
+func (t *templateValue) Set(val string) error {
+	tmpl, err := ParseTemplate(t.name, val)
+	if err != nil {
+		return errors.New("parse error")
+	}
+	*t.tmpl = tmpl
+
+	return nil
+}
+
+// regexpValue is a regular expression.
+type regexpValue struct {
+	re **regexp.Regexp
+}
+
+func (r *regexpValue) String() string {
+	if r.re == nil || *r.re == nil {
+		return ""
+	}
+	return (*r.re).String()
+}
+
+func (r *regexpValue) Set(val string) error {
+	re, err := regexp.Compile(val)
+	if err != nil {
+		return errors.New("parse error")
+	}
+	*r.re = re
+
+	return nil
+}
+
+// tagSchemaFlags are flags of tag schema.
+type tagSchemaFlags struct {
+	prefix          string
+	keys            []Tag
+	backupTypeValue string
+}
+
+// register defines flags of tag schema with the name prefix like "from-".
+func (f *tagSchemaFlags) register(flags *flag.FlagSet, name, usage string) {
+	flags.StringVar(&f.prefix, name+"tag-prefix", "", "prefix of keys of tags"+usage)
+	flags.Var(newTagSliceValue("", &f.keys), name+"tag-keys", "custom keys of tags like Service:BackupService"+usage)
+	flags.StringVar(&f.backupTypeValue, name+"backup-type-value", DefaultBackupTypeValue, "value of BackupType tag"+usage)
+}
+
+// schema returns the tag schema, and returns error when default keys to rename are unknown,
+// keys in the schema are duplicated or keys have aws: prefix which is reserved by AWS.
+func (f *tagSchemaFlags) schema() (TagSchema, error) {
+	schema := TagSchema{Prefix: f.prefix, BackupTypeValue: f.backupTypeValue}
+	if len(f.keys) > 0 {
+		schema.Keys = make(map[string]string)
+	}
+	for _, t := range f.keys {
+		known := false
+		for _, k := range tagKeys {
+			if k == t.Key {
+				known = true
+			}
+		}
+		if !known || t.Value == "" {
+			return schema, fmt.Errorf("invalid tag key: %s", t.Key)
+		}
+		schema.Keys[t.Key] = t.Value
+	}
+
+	keys := make(map[string]bool)
+	for _, k := range tagKeys {
+		key := schema.Key(k)
+		if strings.HasPrefix(key, "aws:") {
+			return schema, fmt.Errorf("tag key can not have aws: prefix: %s", key)
+		}
+		if keys[key] {
+			return schema, fmt.Errorf("duplicate tag key: %s", key)
+		}
+		keys[key] = true
+	}
+	return schema, nil
+}
+
+var principalPattern = regexp.MustCompile(`^(\d{12}|arn:aws[\w-]*:organizations::\d{12}:(organization/o-[a-z0-9]+|ou/o-[a-z0-9]+/ou-[a-z0-9]+-[a-z0-9]+))$`)
+
+// copyDestination is a region where backups are copied to.
+type copyDestination struct {
+	region string
+	// generation is the number of backup generation in the region, zero means same as the source region.
+	generation int
+}
+
+type copyDestinationSliceValue []copyDestination
+
+func (s *copyDestinationSliceValue) String() string {
+	var destStrs []string
+	for _, d := range *s {
+		if d.generation > 0 {
+			destStrs = append(destStrs, fmt.Sprintf("%s:%d", d.region, d.generation))
+			continue
+		}
+		destStrs = append(destStrs, d.region)
+	}
+	return strings.Join(destStrs, ",")
+}
+
+func (s *copyDestinationSliceValue) Set(val string) error {
+	rawDests := strings.Split(val, ",")
+
+	var dests []copyDestination
+	for _, d := range rawDests {
+		kv := strings.Split(d, ":")
+		if len(kv) > 2 || kv[0] == "" {
+			return errors.New("parse error")
+		}
+
+		dest := copyDestination{region: kv[0]}
+		if len(kv) == 2 {
+			g, err := strconv.Atoi(kv[1])
+			if err != nil || g < 1 {
+				return errors.New("parse error")
+			}
+			dest.generation = g
+		}
+
+		dests = append(dests, dest)
+	}
+
+	*s = copyDestinationSliceValue(dests)
+
+	return nil
+}
+
+type filterSliceValue []*ec2.Filter
+
+func (s *filterSliceValue) String() string {
+	var filterStrs []string
+	for _, f := range *s {
+		for _, v := range f.Values {
+			filterStrs = append(filterStrs, fmt.Sprintf("%s=%s", *f.Name, *v))
+		}
+	}
+	return strings.Join(filterStrs, ",")
+}
+
+func (s *filterSliceValue) Set(val string) error {
+	rawFilters := strings.Split(val, ",")
+
+	var filters []*ec2.Filter
+	for _, f := range rawFilters {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return errors.New("parse error")
+		}
+
+		// the same filter name given more than once matches any of the values
+		var found bool
+		for _, filter := range filters {
+			if *filter.Name == kv[0] {
+				filter.Values = append(filter.Values, aws.String(kv[1]))
+				found = true
+				break
+			}
+		}
+		if !found {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String(kv[0]),
+				Values: []*string{aws.String(kv[1])},
+			})
+		}
+	}
+
+	*s = filterSliceValue(filters)
+
+	return nil
+}
+
 // Run invokes the CLI with the given arguments.
 func (c *CLI) Run(args []string) int {
-	flags := flag.NewFlagSet(Name, flag.ContinueOnError)
+	flagArgs := args[1:]
+	if len(args) > 1 {
+		switch args[1] {
+		case commandMigrateTags:
+			return c.runMigrateTags(args)
+		case commandAdopt:
+			return c.runAdopt(args)
+		case commandGC:
+			return c.runGC(args)
+		case commandRestore:
+			return c.runRestore(args)
+		case commandRestoreVolume:
+			return c.runRestoreVolume(args)
+		case commandCreate, commandRotate, commandList:
+			c.command = args[1]
+			flagArgs = args[2:]
+		case commandPrune:
+			c.command = commandRotate
+			flagArgs = args[2:]
+		}
+	}
+
+	flags := c.newFlagSet()
+	if err := flags.Parse(flagArgs); err != nil {
+		return ExitCodeFlagParseError
+	}
+
+	if c.flags.config != "" {
+		return c.runConfig(flags)
+	}
+
+	if err := c.validateCommandFlags(flags); err != nil {
+		c.report(ExitCodeFlagParseError, err)
+		return ExitCodeFlagParseError
+	}
+
+	code, err := c.run()
+	if err != nil {
+		c.report(code, err)
+	}
+
+	return code
+}
+
+// runConfig validates all jobs of the config file before running any jobs, and runs the jobs in order.
+// Errors of each job are reported by -mail-to of the job, and the exit code is of the first failed job.
+func (c *CLI) runConfig(flags *flag.FlagSet) int {
+	var others bool
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && f.Name != "dry-run" {
+			others = true
+		}
+	})
+	if others || c.command != "" {
+		fmt.Fprintln(c.errStream, "-config can not be used with commands and options other than -dry-run")
+		return ExitCodeFlagParseError
+	}
+
+	config, err := LoadConfig(c.flags.config)
+	if err != nil {
+		fmt.Fprintf(c.errStream, "failed to load config: %s\n", err.Error())
+		return ExitCodeFlagParseError
+	}
+
+	var jobs []*CLI
+	for i, j := range config.Jobs {
+		job := &CLI{outStream: c.outStream, errStream: c.errStream, inStream: c.inStream, mail: c.mail, command: j.command()}
+		if err := job.parseJob(&j, c.flags.dryRun); err != nil {
+			fmt.Fprintf(c.errStream, "invalid job %s: %s\n", j.label(i), err.Error())
+			return ExitCodeFlagParseError
+		}
+		jobs = append(jobs, job)
+	}
+
+	code := ExitCodeOK
+	for i, job := range jobs {
+		fmt.Fprintf(c.outStream, "job: %s\n", config.Jobs[i].label(i))
+		jobCode, err := job.run()
+		if err != nil {
+			job.report(jobCode, err)
+		}
+		if code == ExitCodeOK {
+			code = jobCode
+		}
+	}
+
+	return code
+}
+
+// parseJob parses and validates options of the job.
+func (c *CLI) parseJob(job *ConfigJob, dryRun bool) error {
+	args, err := job.args()
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		args = append(args, "-dry-run")
+	}
+
+	flags := c.newFlagSet()
+	flags.SetOutput(ioutil.Discard)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := c.validateCommandFlags(flags); err != nil {
+		return err
+	}
+	return c.validate()
+}
+
+// newFlagSet returns the flag set of backup which defines flags of c.
+func (c *CLI) newFlagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(strings.TrimSpace(Name+" "+c.command), flag.ContinueOnError)
 	flags.SetOutput(c.outStream)
 	flags.StringVar(&c.flags.instanceID, "instance-id", "", "instance id")
 	flags.StringVar(&c.flags.instanceID, "i", "", "instance id(Short)")
+	flags.Var((*filterSliceValue)(&c.flags.instanceFilters), "instance-filter", "filters of instances to backup")
+	flags.IntVar(&c.flags.concurrency, "concurrency", 4, "number of instances to backup at the same time with -instance-filter")
+	flags.DurationVar(&c.flags.instanceTimeout, "instance-timeout", 0, "deadline of backup per instance with -instance-filter")
 	flags.IntVar(&c.flags.generation, "backup-generation", 10, "number of backup generation")
 	flags.IntVar(&c.flags.generation, "g", 10, "number of backup generation(Short)")
+	flags.IntVar(&c.flags.keepDaily, "keep-daily", 0, "number of days to keep the newest backup of the day instead of -backup-generation")
+	flags.IntVar(&c.flags.keepWeekly, "keep-weekly", 0, "number of weeks to keep the newest backup of the week instead of -backup-generation")
+	flags.IntVar(&c.flags.keepMonthly, "keep-monthly", 0, "number of months to keep the newest backup of the month instead of -backup-generation")
+	flags.IntVar(&c.flags.keepYearly, "keep-yearly", 0, "number of years to keep the newest backup of the year instead of -backup-generation")
+	flags.Var((*durationValue)(&c.flags.maxAge), "max-age", "duration after which backups are deregistered instead of -backup-generation like 30d")
+	flags.IntVar(&c.flags.minGenerations, "min-generations", 0, "minimum number of backup generation to keep even if these are older than -max-age")
+	flags.IntVar(&c.flags.archiveGeneration, "archive-generation", 0, "number of backup generation in the EBS Snapshots archive tier after -backup-generation")
 	flags.StringVar(&c.flags.region, "region", "", "region")
 	flags.StringVar(&c.flags.region, "r", "", "region(Short)")
 	flags.StringVar(&c.flags.service, "service-tag", "", "value of Service tag")
 	flags.StringVar(&c.flags.service, "s", "", "value of Service tag(Short)")
 	flags.Var(newTagSliceValue("", &c.flags.customTags), "custom-tags", "key-value of Cunstom tags")
 	flags.Var(newTagSliceValue("", &c.flags.customTags), "c", "key-value of Cunstom tags(Short)")
+	flags.Var((*copyDestinationSliceValue)(&c.flags.copyDestinations), "copy-to-region", "regions to copy backups to, with optional number of backup generation like region:generation")
+	flags.Var((*stringSliceValue)(&c.flags.shareWith), "share-with", "AWS account ids, organization ARNs or organizational unit ARNs to share backups with")
+	flags.StringVar(&c.flags.kmsKeyID, "encrypt-kms-key-id", "", "KMS key id to create an encrypted copy of backups")
+	flags.StringVar(&c.flags.encryptRegion, "encrypt-region", "", "region to create an encrypted copy of backups (default the backup region)")
+	flags.BoolVar(&c.flags.deregisterUnencrypted, "deregister-unencrypted", false, "deregister unencrypted backups after the encrypted copy is created")
+	flags.Var((*stringSliceValue)(&c.flags.excludeDevices), "exclude-devices", "device names of EBS volumes to exclude from backup")
+	flags.Var((*stringSliceValue)(&c.flags.excludeVolumeIDs), "exclude-volume-ids", "EBS volume ids to exclude from backup")
+	flags.Var(newTagSliceValue("", &c.flags.excludeVolumeTags), "exclude-volume-tags", "key-value of tags of EBS volumes to exclude from backup")
+	flags.BoolVar(&c.flags.snapshotOnly, "snapshot-only", false, "create backups by EBS Snapshots without machine image")
+	flags.Var((*durationValue)(&c.flags.deprecateAfter), "deprecate-after", "duration after which backups are deprecated like 30d or 12h")
+	flags.Var((*durationValue)(&c.flags.backupInterval), "backup-interval", "interval of backups to deprecate backups after backup generation x interval")
+	flags.BoolVar(&c.flags.rotateDeprecated, "rotate-deprecated", false, "deregister deprecated backups even if these are within backup generation")
+	flags.Var(&templateValue{name: "name", tmpl: &c.flags.nameTemplate}, "name-template", "Go template of machine image name")
+	flags.Var(&templateValue{name: "description", tmpl: &c.flags.descriptionTemplate}, "description-template", "Go template of machine image description")
+	flags.Var((*stringSliceValue)(&c.flags.propagateTags), "propagate-tags", "keys of tags of the instance and EBS volumes to copy to AMI and EBS Snapshots")
+	flags.Var(&regexpValue{re: &c.flags.propagateTagsRegex}, "propagate-tags-regex", "regular expression of keys of tags of the instance and EBS volumes to copy to AMI and EBS Snapshots")
+	c.flags.tagSchema.register(flags, "", "")
+	flags.Var((*stringSliceValue)(&c.flags.groupBy), "group-by", "keys of tags which identify the generation management group like Service,Environment (default Name,Service)")
+	flags.BoolVar(&c.flags.rotateByInstanceID, "rotate-by-instance-id", false, "manage backup generations by InstanceId tag instead of Name tag, same as -group-by InstanceId,Service")
+	flags.StringVar(&c.flags.name, "name", "", "value of Name tag of backups to rotate or list instead of the instance")
+	flags.BoolVar(&c.flags.all, "all", false, "rotate backups of all generation management groups")
+	flags.Var((*timeValue)(&c.flags.since), "since", "list backups created at or after the time like 2006-01-02 or 2006-01-02T15:04:05Z")
+	flags.Var((*timeValue)(&c.flags.until), "until", "list backups created before the time like 2006-01-02 or 2006-01-02T15:04:05Z")
+	flags.StringVar(&c.flags.output, "output", OutputTable, "output format of listed backups, table, json or csv")
+	flags.BoolVar(&c.flags.dryRun, "dry-run", false, "print backups which would be created and deregistered without changes")
+	flags.StringVar(&c.flags.config, "config", "", "YAML or JSON file of backup jobs")
 	flags.BoolVar(&c.flags.version, "version", false, "print version information")
 	flags.BoolVar(&c.flags.version, "v", false, "print version information(Short)")
 
@@ -108,78 +579,1261 @@ func (c *CLI) Run(args []string) int {
 	flags.StringVar(&c.flags.server, "m", "localhost", "address of mail server(Short)")
 	flags.IntVar(&c.flags.port, "mail-server-port", 25, "port number of mail server")
 	flags.IntVar(&c.flags.port, "p", 25, "port number of mail server(Short)")
-	if err := flags.Parse(args[1:]); err != nil {
-		return ExitCodeFlagParseError
-	}
 
-	code, err := c.run()
-	if err != nil {
-		fmt.Fprintln(c.errStream, err.Error())
-		if c.flags.to != "" && code == ExitCodeAWSError {
-			from := c.flags.from
-			if from == "" {
-				from = "go-create-image-backup@localhost.localdomain"
-			}
-			if mailerr := c.mail.Send(from, c.flags.to, c.flags.server, err.Error(), c.flags.port); mailerr != nil {
-				fmt.Fprintf(c.errStream, mailerr.Error())
-			}
+	return flags
+}
+
+// report prints the error, and sends it by email with -mail-to when it is an AWS error.
+func (c *CLI) report(code int, err error) {
+	fmt.Fprintln(c.errStream, err.Error())
+	if c.flags.to != "" && code == ExitCodeAWSError {
+		from := c.flags.from
+		if from == "" {
+			from = "go-create-image-backup@localhost.localdomain"
+		}
+		if mailerr := c.mail.Send(from, c.flags.to, c.flags.server, err.Error(), c.flags.port); mailerr != nil {
+			fmt.Fprintf(c.errStream, mailerr.Error())
 		}
 	}
+}
 
-	return code
+// listFlags are flags which are used by list command.
+// -dry-run is also accepted because list command makes no changes.
+var listFlags = map[string]bool{
+	"instance-id": true, "i": true, "name": true, "service-tag": true, "s": true,
+	"since": true, "until": true, "output": true, "region": true, "r": true,
+	"tag-prefix": true, "tag-keys": true, "backup-type-value": true, "dry-run": true,
+	"mail-to": true, "t": true, "mail-from": true, "f": true, "mail-server": true, "m": true, "mail-server-port": true, "p": true,
 }
 
-func (c *CLI) run() (int, error) {
-	if c.flags.version {
-		fmt.Fprintf(c.outStream, "%s version %s\n", Name, Version)
-		return ExitCodeOK, nil
+// createFlags are flags which are used to create backups only, and are not used by rotate command.
+var createFlags = map[string]bool{
+	"concurrency": true, "instance-timeout": true, "share-with": true, "deregister-unencrypted": true,
+	"exclude-devices": true, "exclude-volume-ids": true, "exclude-volume-tags": true,
+	"deprecate-after": true, "backup-interval": true, "name-template": true, "description-template": true,
+	"propagate-tags": true, "propagate-tags-regex": true,
+}
+
+// validateCommandFlags returns error when flags which are not used by list or rotate command are set.
+func (c *CLI) validateCommandFlags(flags *flag.FlagSet) error {
+	var unused []string
+	flags.Visit(func(f *flag.Flag) {
+		if (c.command == commandList && !listFlags[f.Name]) || (c.command == commandRotate && createFlags[f.Name]) {
+			unused = append(unused, "-"+f.Name)
+		}
+	})
+	if len(unused) > 0 {
+		return fmt.Errorf("%s can not be used with %s command", strings.Join(unused, ", "), c.command)
 	}
+	return nil
+}
 
-	sess, err := NewAWSSession()
-	if err != nil {
-		return ExitCodeAWSError, fmt.Errorf("create aws session failed: %s", err)
+// validate validates flags of backup.
+func (c *CLI) validate() error {
+	if c.flags.instanceID != "" && len(c.flags.instanceFilters) > 0 {
+		return errors.New("-instance-id and -instance-filter can not be used together")
 	}
 
-	client, err := NewAWSClient(sess, c.flags.region)
-	if err != nil {
-		return ExitCodeAWSError, fmt.Errorf("create aws client failed: %s", err)
+	if c.flags.concurrency < 1 {
+		return errors.New("-concurrency must be greater than 0")
 	}
 
-	backup := &Backup{
-		InstanceID: c.flags.instanceID,
-		Generation: c.flags.generation,
-		Service:    c.flags.service,
-		CustomTags: c.flags.customTags,
-		Client:     client,
+	if c.flags.name != "" && c.command != commandRotate && c.command != commandList {
+		return errors.New("-name can be used with rotate and list commands only")
 	}
 
-	if backup.InstanceID == "" {
-		i, err := backup.Client.GetInstanceID()
-		if err != nil {
-			return ExitCodeAWSError, fmt.Errorf("failed to get instance id: %s", err.Error())
-		}
-		backup.InstanceID = i
+	if c.flags.all && c.command != commandRotate {
+		return errors.New("-all can be used with rotate command only")
 	}
 
-	ctx := context.TODO()
+	if c.flags.all && (c.flags.instanceID != "" || len(c.flags.instanceFilters) > 0 || c.flags.name != "") {
+		return errors.New("-all can not be used with -instance-id, -instance-filter and -name")
+	}
 
-	name, err := backup.Client.GetInstanceName(ctx, backup.InstanceID)
-	if err != nil {
-		return ExitCodeAWSError, fmt.Errorf("failed to get instance name: %s", err.Error())
+	if c.flags.snapshotOnly && (c.flags.all || c.command == commandList) {
+		return errors.New("-snapshot-only can not be used with -all and list command")
 	}
-	backup.Name = name
 
-	imageID, err := backup.Create(ctx)
-	if err != nil {
-		return ExitCodeAWSError, fmt.Errorf("failed to create backup: %s", err.Error())
+	if (!c.flags.since.IsZero() || !c.flags.until.IsZero() || c.flags.output != OutputTable) && c.command != commandList {
+		return errors.New("-since, -until and -output can be used with list command only")
 	}
-	fmt.Fprintf(c.outStream, "create image: %s\n", imageID)
 
-	rotateImageIDs, err := backup.Rotate(ctx, imageID)
-	if err != nil {
-		return ExitCodeAWSError, fmt.Errorf("failed to rotate: %s", err.Error())
+	if c.flags.output != OutputTable && c.flags.output != OutputJSON && c.flags.output != OutputCSV {
+		return errors.New("-output must be table, json or csv")
 	}
-	fmt.Fprintf(c.outStream, "deregister images: %s\n", strings.Join(rotateImageIDs, ", "))
 
-	return ExitCodeOK, nil
+	if !c.flags.since.IsZero() && !c.flags.until.IsZero() && !c.flags.since.Before(c.flags.until) {
+		return errors.New("-since must be before -until")
+	}
+
+	if c.command == commandList && len(c.flags.instanceFilters) > 0 {
+		return errors.New("-instance-filter can not be used with list command")
+	}
+
+	if c.flags.name != "" && c.command == commandRotate && (c.flags.instanceID != "" || len(c.flags.instanceFilters) > 0 || c.flags.rotateByInstanceID) {
+		return errors.New("-name can not be used with -instance-id, -instance-filter and -rotate-by-instance-id")
+	}
+
+	if c.flags.kmsKeyID == "" && (c.flags.encryptRegion != "" || c.flags.deregisterUnencrypted) {
+		return errors.New("-encrypt-region and -deregister-unencrypted require -encrypt-kms-key-id")
+	}
+
+	if c.flags.snapshotOnly && (len(c.flags.copyDestinations) > 0 || len(c.flags.shareWith) > 0 || c.flags.kmsKeyID != "") {
+		return errors.New("-snapshot-only can not be used with -copy-to-region, -share-with and -encrypt-kms-key-id")
+	}
+
+	if c.flags.archiveGeneration < 0 {
+		return errors.New("-archive-generation must be greater than or equal to 0")
+	}
+
+	if c.flags.snapshotOnly && c.flags.archiveGeneration > 0 {
+		return errors.New("-snapshot-only can not be used with -archive-generation")
+	}
+
+	if c.flags.keepDaily < 0 || c.flags.keepWeekly < 0 || c.flags.keepMonthly < 0 || c.flags.keepYearly < 0 {
+		return errors.New("-keep-daily, -keep-weekly, -keep-monthly and -keep-yearly must be greater than or equal to 0")
+	}
+
+	if !c.retentionPolicy().isEmpty() && (c.flags.snapshotOnly || c.flags.archiveGeneration > 0) {
+		return errors.New("-keep-daily, -keep-weekly, -keep-monthly and -keep-yearly can not be used with -snapshot-only and -archive-generation")
+	}
+
+	// deprecation by -backup-interval is derived from -backup-generation which is not used by the retention policy,
+	// and -rotate-deprecated deregisters backups which are kept by the retention policy
+	if !c.retentionPolicy().isEmpty() && (c.flags.backupInterval > 0 || c.flags.rotateDeprecated) {
+		return errors.New("-keep-daily, -keep-weekly, -keep-monthly and -keep-yearly can not be used with -backup-interval and -rotate-deprecated")
+	}
+
+	if c.flags.minGenerations < 0 {
+		return errors.New("-min-generations must be greater than or equal to 0")
+	}
+
+	if c.flags.maxAge == 0 && c.flags.minGenerations > 0 {
+		return errors.New("-min-generations requires -max-age")
+	}
+
+	if c.flags.maxAge > 0 && (!c.retentionPolicy().isEmpty() || c.flags.snapshotOnly || c.flags.archiveGeneration > 0) {
+		return errors.New("-max-age can not be used with -keep-daily, -keep-weekly, -keep-monthly, -keep-yearly, -snapshot-only and -archive-generation")
+	}
+
+	// -max-age is the retention of backups instead of -backup-generation which deprecation by -backup-interval is derived from,
+	// and -rotate-deprecated deregisters backups within -max-age
+	if c.flags.maxAge > 0 && (c.flags.backupInterval > 0 || c.flags.rotateDeprecated) {
+		return errors.New("-max-age and -min-generations can not be used with -backup-interval and -rotate-deprecated")
+	}
+
+	if c.flags.deprecateAfter > 0 && c.flags.backupInterval > 0 {
+		return errors.New("-deprecate-after and -backup-interval can not be used together")
+	}
+
+	if c.flags.snapshotOnly && (c.flags.deprecateAfter > 0 || c.flags.backupInterval > 0 || c.flags.rotateDeprecated) {
+		return errors.New("-snapshot-only can not be used with -deprecate-after, -backup-interval and -rotate-deprecated")
+	}
+
+	if c.flags.snapshotOnly && (c.flags.nameTemplate != nil || c.flags.descriptionTemplate != nil) {
+		return errors.New("-snapshot-only can not be used with -name-template and -description-template")
+	}
+
+	if c.flags.snapshotOnly && (len(c.flags.propagateTags) > 0 || c.flags.propagateTagsRegex != nil) {
+		return errors.New("-snapshot-only can not be used with -propagate-tags and -propagate-tags-regex")
+	}
+
+	if _, err := c.flags.tagSchema.schema(); err != nil {
+		return err
+	}
+
+	if c.flags.rotateByInstanceID && len(c.flags.groupBy) > 0 {
+		return errors.New("-rotate-by-instance-id and -group-by can not be used together")
+	}
+
+	if err := c.validateGroupBy(); err != nil {
+		return err
+	}
+
+	if err := c.validateTemplates(); err != nil {
+		return err
+	}
+
+	for _, p := range c.flags.shareWith {
+		if !principalPattern.MatchString(p) {
+			return fmt.Errorf("invalid -share-with value: %s", p)
+		}
+	}
+
+	// the backup region is validated again by run when it is taken from the instance metadata
+	if c.flags.region != "" {
+		if err := c.validateRegion(c.flags.region); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateRegion validates flags of other regions against the backup region.
+func (c *CLI) validateRegion(region string) error {
+	if c.flags.encryptRegion != "" && c.flags.encryptRegion != region && c.flags.deregisterUnencrypted {
+		return errors.New("-deregister-unencrypted can not be used with -encrypt-region other than the backup region")
+	}
+
+	// copies in the backup region collide with the names of backups, and are rotated with backups as the same group
+	for _, d := range c.flags.copyDestinations {
+		if d.region == region {
+			return fmt.Errorf("-copy-to-region can not be the backup region: %s", region)
+		}
+	}
+
+	return nil
+}
+
+func (c *CLI) run() (int, error) {
+	if c.flags.version {
+		fmt.Fprintf(c.outStream, "%s version %s\n", Name, Version)
+		return ExitCodeOK, nil
+	}
+
+	if err := c.validate(); err != nil {
+		return ExitCodeFlagParseError, err
+	}
+
+	sess, err := NewAWSSession()
+	if err != nil {
+		return ExitCodeAWSError, fmt.Errorf("create aws session failed: %s", err)
+	}
+
+	client, err := NewAWSClient(sess, c.flags.region)
+	if err != nil {
+		return ExitCodeAWSError, fmt.Errorf("create aws client failed: %s", err)
+	}
+
+	clients := &awsClients{backup: client, encrypt: client, copies: make(map[string]AWS)}
+
+	region, err := client.GetRegion()
+	if err != nil {
+		return ExitCodeAWSError, fmt.Errorf("failed to get region: %s", err.Error())
+	}
+	if err := c.validateRegion(region); err != nil {
+		return ExitCodeFlagParseError, err
+	}
+	if c.flags.encryptRegion != "" && c.flags.encryptRegion != region {
+		encryptClient, err := NewAWSClient(sess, c.flags.encryptRegion)
+		if err != nil {
+			return ExitCodeAWSError, fmt.Errorf("create aws client for %s failed: %s", c.flags.encryptRegion, err)
+		}
+		clients.encrypt = encryptClient
+		clients.encryptInOtherRegion = true
+	}
+
+	for _, d := range c.flags.copyDestinations {
+		copyClient, err := NewAWSClient(sess, d.region)
+		if err != nil {
+			return ExitCodeAWSError, fmt.Errorf("create aws client for %s failed: %s", d.region, err)
+		}
+		clients.copies[d.region] = copyClient
+	}
+
+	ctx := context.TODO()
+
+	if c.command == commandList {
+		return c.list(ctx, clients)
+	}
+
+	if c.command == commandRotate {
+		return c.runGroups(ctx, clients)
+	}
+
+	if len(c.flags.instanceFilters) > 0 {
+		return c.runFleet(ctx, clients)
+	}
+
+	instanceID := c.flags.instanceID
+	if instanceID == "" {
+		i, err := client.GetInstanceID()
+		if err != nil {
+			return ExitCodeAWSError, fmt.Errorf("failed to get instance id: %s", err.Error())
+		}
+		instanceID = i
+	}
+
+	result := c.backup(ctx, clients, instanceID)
+	for _, m := range result.messages {
+		fmt.Fprintln(c.outStream, m)
+	}
+	if result.err != nil {
+		return ExitCodeAWSError, result.err
+	}
+
+	return ExitCodeOK, nil
+}
+
+// runFleet backups every instance which matches the instance filters.
+func (c *CLI) runFleet(ctx context.Context, clients *awsClients) (int, error) {
+	filters := c.flags.instanceFilters
+
+	// instances which are terminated or shutting down can not be backed up
+	var hasStateFilter bool
+	for _, f := range filters {
+		if *f.Name == "instance-state-name" {
+			hasStateFilter = true
+		}
+	}
+	if !hasStateFilter {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("instance-state-name"),
+			Values: []*string{aws.String("running"), aws.String("stopped")},
+		})
+	}
+
+	instanceIDs, err := clients.backup.GetInstanceIDs(ctx, filters)
+	if err != nil {
+		return ExitCodeAWSError, fmt.Errorf("failed to get instance ids: %s", err.Error())
+	}
+	if len(instanceIDs) < 1 {
+		return ExitCodeAWSError, errors.New("no instances matched the instance filters")
+	}
+
+	e := &executor{workers: c.flags.concurrency, timeout: c.flags.instanceTimeout}
+	results := e.run(ctx, instanceIDs, func(ctx context.Context, instanceID string) backupResult {
+		return c.backup(ctx, clients, instanceID)
+	})
+
+	for _, result := range results {
+		messages := result.messages
+		if result.err != nil {
+			messages = append(messages, result.err.Error())
+		}
+		fmt.Fprintf(c.outStream, "%s: %s\n", result.instanceID, strings.Join(messages, ", "))
+	}
+
+	if err := newFleetError(results); err != nil {
+		return ExitCodeAWSError, err
+	}
+
+	return ExitCodeOK, nil
+}
+
+// runGroups rotates backups of generation management groups without creating backups.
+func (c *CLI) runGroups(ctx context.Context, clients *awsClients) (int, error) {
+	backups, err := c.targetBackups(ctx, clients)
+	if err != nil {
+		return ExitCodeAWSError, err
+	}
+
+	var results []backupResult
+	for _, backup := range backups {
+		result := c.rotate(ctx, clients, backup, backupResult{instanceID: backup.GroupLabel()})
+		results = append(results, result)
+
+		messages := result.messages
+		if result.err != nil {
+			messages = append(messages, result.err.Error())
+		}
+		fmt.Fprintf(c.outStream, "%s: %s\n", result.instanceID, strings.Join(messages, ", "))
+	}
+
+	if err := newFleetError(results); err != nil {
+		return ExitCodeAWSError, err
+	}
+
+	return ExitCodeOK, nil
+}
+
+// targetBackups returns backups of generation management groups by -all, -name, -instance-filter or -instance-id.
+// Instances which are terminated are also targets, so that backups of decommissioned instances can be rotated.
+func (c *CLI) targetBackups(ctx context.Context, clients *awsClients) ([]*Backup, error) {
+	if c.flags.all {
+		backups, err := BackupGroups(ctx, c.newBackup(clients, ""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get backup groups: %s", err.Error())
+		}
+		return backups, nil
+	}
+
+	if c.flags.name != "" {
+		backup := c.newBackup(clients, "")
+		backup.Name = c.flags.name
+		return []*Backup{backup}, nil
+	}
+
+	var instanceIDs []string
+	if len(c.flags.instanceFilters) > 0 {
+		ids, err := clients.backup.GetInstanceIDs(ctx, c.flags.instanceFilters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get instance ids: %s", err.Error())
+		}
+		instanceIDs = ids
+	} else if c.flags.instanceID != "" {
+		instanceIDs = []string{c.flags.instanceID}
+	} else {
+		id, err := clients.backup.GetInstanceID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get instance id: %s", err.Error())
+		}
+		instanceIDs = []string{id}
+	}
+
+	var backups []*Backup
+	for _, id := range instanceIDs {
+		backup := c.newBackup(clients, id)
+		name, err := backup.Client.GetInstanceName(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get instance name: %s", err.Error())
+		}
+		groupsByName := false
+		for _, key := range backup.groupBy() {
+			if key == TagName {
+				groupsByName = true
+			}
+		}
+		if name != id || !groupsByName {
+			backup.Name = name
+			backups = append(backups, backup)
+			continue
+		}
+
+		// the instance has no Name tag like terminated instances, so that the group is resolved by existing backups of the instance
+		group, ok, err := InstanceBackupGroup(ctx, backup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get backups of %s: %s", id, err.Error())
+		}
+		if !ok {
+			return nil, fmt.Errorf("backups of %s are not found by InstanceId tag, use -name to rotate backups of instances without Name tag", id)
+		}
+		backups = append(backups, group)
+	}
+	return backups, nil
+}
+
+// list prints machine images of backups which match -name, -service-tag, -instance-id, -since and -until.
+func (c *CLI) list(ctx context.Context, clients *awsClients) (int, error) {
+	filter := ListFilter{
+		Name:       c.flags.name,
+		Service:    c.flags.service,
+		InstanceID: c.flags.instanceID,
+		Since:      c.flags.since,
+		Until:      c.flags.until,
+	}
+
+	backups, err := ListBackups(ctx, c.newBackup(clients, ""), filter)
+	if err != nil {
+		return ExitCodeAWSError, fmt.Errorf("failed to list backups: %s", err.Error())
+	}
+
+	if err := WriteBackupImages(c.outStream, c.flags.output, backups); err != nil {
+		return ExitCodeAWSError, fmt.Errorf("failed to write backups: %s", err.Error())
+	}
+
+	return ExitCodeOK, nil
+}
+
+// newSubcommandFlagSet returns the flag set of the subcommand which has its own flags with -region option.
+func (c *CLI) newSubcommandFlagSet(command string, region *string) *flag.FlagSet {
+	flags := flag.NewFlagSet(Name+" "+command, flag.ContinueOnError)
+	flags.SetOutput(c.outStream)
+	flags.StringVar(region, "region", "", "region")
+	flags.StringVar(region, "r", "", "region(Short)")
+	return flags
+}
+
+// newClient returns the AWS client of the region and the tag schema of existing backups for the subcommand.
+// Errors are printed, and the exit code is returned unless it is ExitCodeOK.
+func (c *CLI) newClient(region string, schemaFlags *tagSchemaFlags) (*AWSClient, TagSchema, int) {
+	schema, err := schemaFlags.schema()
+	if err != nil {
+		fmt.Fprintln(c.errStream, err.Error())
+		return nil, schema, ExitCodeFlagParseError
+	}
+
+	sess, err := NewAWSSession()
+	if err != nil {
+		fmt.Fprintf(c.errStream, "create aws session failed: %s\n", err)
+		return nil, schema, ExitCodeAWSError
+	}
+
+	client, err := NewAWSClient(sess, region)
+	if err != nil {
+		fmt.Fprintf(c.errStream, "create aws client failed: %s\n", err)
+		return nil, schema, ExitCodeAWSError
+	}
+
+	return client, schema, ExitCodeOK
+}
+
+// runMigrateTags retags existing backups from a tag schema to another tag schema.
+func (c *CLI) runMigrateTags(args []string) int {
+	var region string
+	var from, to tagSchemaFlags
+
+	flags := c.newSubcommandFlagSet(commandMigrateTags, &region)
+	from.register(flags, "from-", " of existing backups")
+	to.register(flags, "", " to retag existing backups")
+	if err := flags.Parse(args[2:]); err != nil {
+		return ExitCodeFlagParseError
+	}
+
+	fromSchema, err := from.schema()
+	if err != nil {
+		fmt.Fprintln(c.errStream, err.Error())
+		return ExitCodeFlagParseError
+	}
+	client, toSchema, code := c.newClient(region, &to)
+	if code != ExitCodeOK {
+		return code
+	}
+
+	migration := &TagMigration{From: fromSchema, To: toSchema, Client: client}
+	migratedIDs, err := migration.Run(context.TODO())
+	fmt.Fprintf(c.outStream, "migrate tags: %s\n", strings.Join(migratedIDs, ", "))
+	if err != nil {
+		fmt.Fprintf(c.errStream, "failed to migrate tags: %s\n", err.Error())
+		return ExitCodeAWSError
+	}
+
+	return ExitCodeOK
+}
+
+// runAdopt backfills InstanceId tag of existing backups which were created without it.
+func (c *CLI) runAdopt(args []string) int {
+	var region string
+	var schemaFlags tagSchemaFlags
+
+	flags := c.newSubcommandFlagSet(commandAdopt, &region)
+	schemaFlags.register(flags, "", " of existing backups")
+	if err := flags.Parse(args[2:]); err != nil {
+		return ExitCodeFlagParseError
+	}
+
+	client, schema, code := c.newClient(region, &schemaFlags)
+	if code != ExitCodeOK {
+		return code
+	}
+
+	adoption := &Adoption{Schema: schema, Client: client}
+	adoptedIDs, unknownIDs, err := adoption.Run(context.TODO())
+	fmt.Fprintf(c.outStream, "adopt: %s\n", strings.Join(adoptedIDs, ", "))
+	if len(unknownIDs) > 0 {
+		fmt.Fprintf(c.errStream, "unknown source instance: %s\n", strings.Join(unknownIDs, ", "))
+	}
+	if err != nil {
+		fmt.Fprintf(c.errStream, "failed to adopt backups: %s\n", err.Error())
+		return ExitCodeAWSError
+	}
+
+	return ExitCodeOK
+}
+
+// retentionPolicy returns the retention policy of -keep-daily, -keep-weekly, -keep-monthly and -keep-yearly.
+func (c *CLI) retentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		Daily:   c.flags.keepDaily,
+		Weekly:  c.flags.keepWeekly,
+		Monthly: c.flags.keepMonthly,
+		Yearly:  c.flags.keepYearly,
+	}
+}
+
+// runGC deletes orphaned snapshots of backups whose machine image no longer exists after confirmation,
+// and reports machine images whose snapshots are partially missing.
+func (c *CLI) runGC(args []string) int {
+	var region string
+	var yes bool
+	var schemaFlags tagSchemaFlags
+
+	flags := c.newSubcommandFlagSet(commandGC, &region)
+	flags.BoolVar(&yes, "yes", false, "delete orphaned snapshots without confirmation")
+	schemaFlags.register(flags, "", " of existing backups")
+	if err := flags.Parse(args[2:]); err != nil {
+		return ExitCodeFlagParseError
+	}
+
+	client, schema, code := c.newClient(region, &schemaFlags)
+	if code != ExitCodeOK {
+		return code
+	}
+
+	return c.gc(context.TODO(), &GarbageCollection{Schema: schema, Client: client}, yes)
+}
+
+func (c *CLI) gc(ctx context.Context, gc *GarbageCollection, yes bool) int {
+	orphaned, broken, err := gc.Find(ctx)
+	if err != nil {
+		fmt.Fprintf(c.errStream, "failed to find orphaned snapshots: %s\n", err.Error())
+		return ExitCodeAWSError
+	}
+
+	for _, b := range broken {
+		fmt.Fprintf(c.outStream, "broken image: %s (missing snapshots: %s)\n", *b.Image.ImageId, strings.Join(b.MissingSnapshotIDs, ", "))
+	}
+
+	if len(orphaned) < 1 {
+		fmt.Fprintln(c.outStream, "orphaned snapshots are not found")
+		return ExitCodeOK
+	}
+
+	var snapshotIDs []string
+	var totalSize int64
+	for _, o := range orphaned {
+		days := int(time.Since(aws.TimeValue(o.Snapshot.StartTime)).Hours() / 24)
+		fmt.Fprintf(c.outStream, "orphaned snapshot: %s (image: %s, size: %d GiB, age: %d days)\n",
+			*o.Snapshot.SnapshotId, o.ImageID, aws.Int64Value(o.Snapshot.VolumeSize), days)
+		snapshotIDs = append(snapshotIDs, *o.Snapshot.SnapshotId)
+		totalSize += aws.Int64Value(o.Snapshot.VolumeSize)
+	}
+	fmt.Fprintf(c.outStream, "total: %d snapshots, %d GiB\n", len(orphaned), totalSize)
+
+	if !yes && !c.confirm(fmt.Sprintf("delete %d snapshots?", len(orphaned))) {
+		fmt.Fprintln(c.outStream, "canceled")
+		return ExitCodeOK
+	}
+
+	// snapshots which are deleted are printed even when the others failed
+	err = gc.Client.DeleteSnapshots(ctx, snapshotIDs)
+	deleteErr, partial := err.(*deleteSnapshotsError)
+	if err != nil && !partial {
+		fmt.Fprintf(c.errStream, "failed to delete snapshots: %s\n", err.Error())
+		return ExitCodeAWSError
+	}
+	var deleted []string
+	for _, id := range snapshotIDs {
+		if partial && deleteErr.failed(id) {
+			continue
+		}
+		deleted = append(deleted, id)
+	}
+	if len(deleted) > 0 {
+		fmt.Fprintf(c.outStream, "delete snapshots: %s\n", strings.Join(deleted, ", "))
+	}
+	if err != nil {
+		fmt.Fprintf(c.errStream, "failed to delete snapshots: %s\n", err.Error())
+		return ExitCodeAWSError
+	}
+
+	return ExitCodeOK
+}
+
+// confirm asks the question and returns whether the answer is yes.
+func (c *CLI) confirm(question string) bool {
+	if c.inStream == nil {
+		return false
+	}
+	fmt.Fprintf(c.outStream, "%s [y/N]: ", question)
+	var answer string
+	fmt.Fscanln(c.inStream, &answer)
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
+
+// runRestore launches an instance from a backup.
+func (c *CLI) runRestore(args []string) int {
+	var region string
+	var schemaFlags tagSchemaFlags
+	restore := &Restore{}
+
+	flags := c.newSubcommandFlagSet(commandRestore, &region)
+	registerRestoreFlags(flags, restore)
+	flags.StringVar(&restore.Settings.InstanceType, "instance-type", "", "instance type (default the source instance)")
+	flags.StringVar(&restore.Settings.SubnetID, "subnet-id", "", "subnet id (default the source instance)")
+	flags.Var((*stringSliceValue)(&restore.Settings.SecurityGroupIDs), "security-group-ids", "security group ids (default the source instance)")
+	flags.StringVar(&restore.Settings.IAMInstanceProfile, "iam-instance-profile", "", "name or ARN of IAM instance profile (default the source instance)")
+	flags.StringVar(&restore.Settings.KeyName, "key-name", "", "name of key pair (default the source instance)")
+	schemaFlags.register(flags, "", " of existing backups")
+	if err := flags.Parse(args[2:]); err != nil {
+		return ExitCodeFlagParseError
+	}
+
+	if err := validateRestoreFlags(restore); err != nil {
+		fmt.Fprintln(c.errStream, err.Error())
+		return ExitCodeFlagParseError
+	}
+
+	client, schema, code := c.newClient(region, &schemaFlags)
+	if code != ExitCodeOK {
+		return code
+	}
+	restore.Schema = schema
+	restore.Client = client
+
+	return c.restore(context.TODO(), restore)
+}
+
+func (c *CLI) restore(ctx context.Context, restore *Restore) int {
+	image, err := restore.Image(ctx)
+	if err != nil {
+		fmt.Fprintf(c.errStream, "failed to find backup: %s\n", err.Error())
+		return ExitCodeAWSError
+	}
+
+	instanceID, err := restore.Run(ctx, image)
+	if err != nil {
+		fmt.Fprintf(c.errStream, "failed to restore %s: %s\n", *image.ImageId, err.Error())
+		return ExitCodeAWSError
+	}
+	fmt.Fprintf(c.outStream, "restore instance: %s (image: %s, created at %s)\n", instanceID, *image.ImageId, aws.StringValue(image.CreationDate))
+
+	return ExitCodeOK
+}
+
+// registerRestoreFlags defines flags to select the backup to restore.
+func registerRestoreFlags(flags *flag.FlagSet, restore *Restore) {
+	flags.StringVar(&restore.ImageID, "image", "", "machine image id of the backup to restore, or latest")
+	flags.StringVar(&restore.Name, "name", "", "value of Name tag of backups to find the latest backup")
+	flags.StringVar(&restore.Service, "service-tag", "", "value of Service tag of backups to find the latest backup")
+	flags.StringVar(&restore.Service, "s", "", "value of Service tag of backups to find the latest backup(Short)")
+	flags.Var((*timeValue)(&restore.Before), "before", "find the latest backup created before the time like 2006-01-02 or 2006-01-02T15:04:05Z")
+}
+
+// validateRestoreFlags validates flags to select the backup to restore.
+func validateRestoreFlags(restore *Restore) error {
+	if restore.ImageID == "" {
+		return errors.New("-image is required")
+	}
+
+	if restore.ImageID == LatestImage && restore.Name == "" {
+		return errors.New("-image latest requires -name")
+	}
+
+	if restore.ImageID != LatestImage && (restore.Name != "" || restore.Service != "" || !restore.Before.IsZero()) {
+		return errors.New("-name, -service-tag and -before can be used with -image latest only")
+	}
+
+	return nil
+}
+
+// runRestoreVolume creates an EBS volume from a snapshot of a backup.
+func (c *CLI) runRestoreVolume(args []string) int {
+	var region string
+	var schemaFlags tagSchemaFlags
+	restore := &Restore{}
+	volumeRestore := &VolumeRestore{}
+
+	flags := c.newSubcommandFlagSet(commandRestoreVolume, &region)
+	registerRestoreFlags(flags, restore)
+	flags.StringVar(&volumeRestore.Device, "device", "", "device name of the EBS volume in the backup like /dev/sdf")
+	flags.StringVar(&volumeRestore.AvailabilityZone, "availability-zone", "", "availability zone to create the EBS volume in (default the availability zone of -instance-id)")
+	flags.StringVar(&volumeRestore.InstanceID, "instance-id", "", "instance id to attach the EBS volume to")
+	flags.StringVar(&volumeRestore.AttachDevice, "attach-device", "", "device name to attach the EBS volume at (default -device)")
+	schemaFlags.register(flags, "", " of existing backups")
+	if err := flags.Parse(args[2:]); err != nil {
+		return ExitCodeFlagParseError
+	}
+
+	if err := validateRestoreFlags(restore); err != nil {
+		fmt.Fprintln(c.errStream, err.Error())
+		return ExitCodeFlagParseError
+	}
+
+	if volumeRestore.Device == "" {
+		fmt.Fprintln(c.errStream, "-device is required")
+		return ExitCodeFlagParseError
+	}
+
+	if volumeRestore.AvailabilityZone == "" && volumeRestore.InstanceID == "" {
+		fmt.Fprintln(c.errStream, "-availability-zone or -instance-id is required")
+		return ExitCodeFlagParseError
+	}
+
+	if volumeRestore.AttachDevice != "" && volumeRestore.InstanceID == "" {
+		fmt.Fprintln(c.errStream, "-attach-device requires -instance-id")
+		return ExitCodeFlagParseError
+	}
+
+	client, schema, code := c.newClient(region, &schemaFlags)
+	if code != ExitCodeOK {
+		return code
+	}
+	restore.Schema = schema
+	restore.Client = client
+	volumeRestore.Schema = schema
+	volumeRestore.Client = client
+
+	return c.restoreVolume(context.TODO(), restore, volumeRestore)
+}
+
+func (c *CLI) restoreVolume(ctx context.Context, restore *Restore, volumeRestore *VolumeRestore) int {
+	image, err := restore.Image(ctx)
+	if err != nil {
+		fmt.Fprintf(c.errStream, "failed to find backup: %s\n", err.Error())
+		return ExitCodeAWSError
+	}
+
+	volumeID, err := volumeRestore.Run(ctx, image)
+	if err != nil {
+		fmt.Fprintf(c.errStream, "failed to restore %s of %s: %s\n", volumeRestore.Device, *image.ImageId, err.Error())
+		return ExitCodeAWSError
+	}
+
+	if volumeRestore.InstanceID != "" {
+		fmt.Fprintf(c.outStream, "restore volume: %s (image: %s, attached to %s at %s)\n", volumeID, *image.ImageId, volumeRestore.InstanceID, volumeRestore.attachDevice())
+	} else {
+		fmt.Fprintf(c.outStream, "restore volume: %s (image: %s)\n", volumeID, *image.ImageId)
+	}
+
+	return ExitCodeOK
+}
+
+// validateGroupBy validates that keys of -group-by are tags of backups.
+func (c *CLI) validateGroupBy() error {
+	for _, key := range c.flags.groupBy {
+		valid := key == TagName || key == TagService || key == TagInstanceID
+		for _, t := range c.flags.customTags {
+			if t.Key == key {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid -group-by key: %s, it must be Name, Service, InstanceId or a key of -custom-tags", key)
+		}
+	}
+	return nil
+}
+
+// validateTemplates renders templates of machine image name and description with sample data,
+// so that unknown fields and keys of custom tags are found before backups.
+func (c *CLI) validateTemplates() error {
+	tags := make(map[string]string)
+	for _, t := range c.flags.customTags {
+		tags[t.Key] = t.Value
+	}
+	now := time.Now()
+	data := TemplateData{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "name",
+		Service:    c.flags.service,
+		Time:       now.Local(),
+		UTC:        now.UTC(),
+		Sequence:   1,
+		Tags:       tags,
+	}
+
+	if c.flags.nameTemplate != nil {
+		if _, err := renderTemplate(c.flags.nameTemplate, data); err != nil {
+			return fmt.Errorf("invalid -name-template: %s", err.Error())
+		}
+	}
+	if c.flags.descriptionTemplate != nil {
+		if _, err := renderTemplate(c.flags.descriptionTemplate, data); err != nil {
+			return fmt.Errorf("invalid -description-template: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// backupResult is the result of backup for an instance.
+type backupResult struct {
+	instanceID string
+	imageID    string
+	// messages are the outputs of each succeeded step of backup.
+	messages []string
+	err      error
+}
+
+// awsClients are AWS clients for each region which is used by backup.
+type awsClients struct {
+	backup AWS
+	// encrypt is same as backup unless -encrypt-region is other than the backup region.
+	encrypt              AWS
+	encryptInOtherRegion bool
+	copies               map[string]AWS
+}
+
+// newBackup returns the backup of the instance configured by flags.
+func (c *CLI) newBackup(clients *awsClients, instanceID string) *Backup {
+	// tag schema is validated by run
+	schema, _ := c.flags.tagSchema.schema()
+
+	backup := &Backup{
+		InstanceID:          instanceID,
+		Generation:          c.flags.generation,
+		Retention:           c.retentionPolicy(),
+		MaxAge:              c.flags.maxAge,
+		MinGenerations:      c.flags.minGenerations,
+		ArchiveGeneration:   c.flags.archiveGeneration,
+		Service:             c.flags.service,
+		CustomTags:          c.flags.customTags,
+		ShareWith:           c.flags.shareWith,
+		DeprecateAfter:      c.flags.deprecateAfter,
+		BackupInterval:      c.flags.backupInterval,
+		RotateDeprecated:    c.flags.rotateDeprecated,
+		NameTemplate:        c.flags.nameTemplate,
+		DescriptionTemplate: c.flags.descriptionTemplate,
+		Exclude: ExcludeVolumes{
+			Devices:   c.flags.excludeDevices,
+			VolumeIDs: c.flags.excludeVolumeIDs,
+			Tags:      c.flags.excludeVolumeTags,
+		},
+		PropagateTags: TagFilter{
+			Keys:    c.flags.propagateTags,
+			Pattern: c.flags.propagateTagsRegex,
+		},
+		Schema:  schema,
+		GroupBy: c.flags.groupBy,
+		Client:  clients.backup,
+	}
+
+	if c.flags.rotateByInstanceID {
+		backup.GroupBy = InstanceIDGroupBy
+	}
+
+	return backup
+}
+
+// destinationBackup returns the backup in the region of the client.
+// The generation overrides the retention of the backup when it is specified.
+func destinationBackup(backup *Backup, client AWS, generation int) *Backup {
+	b := *backup
+	b.Client = client
+	if generation > 0 {
+		b.Generation = generation
+		b.Retention = RetentionPolicy{}
+		b.MaxAge = 0
+	}
+	return &b
+}
+
+// backup creates a backup of the instance, copies it to other regions and rotates old backups.
+// Old backups are not rotated by create command.
+func (c *CLI) backup(ctx context.Context, clients *awsClients, instanceID string) backupResult {
+	result := backupResult{instanceID: instanceID}
+
+	backup := c.newBackup(clients, instanceID)
+
+	name, err := backup.Client.GetInstanceName(ctx, backup.InstanceID)
+	if err != nil {
+		result.err = fmt.Errorf("failed to get instance name: %s", err.Error())
+		return result
+	}
+	backup.Name = name
+
+	if c.flags.dryRun {
+		return c.planBackup(ctx, clients, backup, result)
+	}
+
+	rotate := c.command != commandCreate
+
+	if c.flags.snapshotOnly {
+		return backupSnapshots(ctx, backup, rotate, result)
+	}
+
+	imageID, err := backup.Create(ctx)
+	if err != nil {
+		result.err = fmt.Errorf("failed to create backup: %s", err.Error())
+		return result
+	}
+	result.imageID = imageID
+	result.messages = append(result.messages, fmt.Sprintf("create image: %s", imageID))
+
+	var encryptedImageID string
+	if c.flags.kmsKeyID != "" {
+		encryptedImageID, err = backup.Encrypt(ctx, imageID, clients.encrypt, c.flags.kmsKeyID)
+		if err != nil {
+			result.err = fmt.Errorf("failed to encrypt backup: %s", err.Error())
+			return result
+		}
+		result.messages = append(result.messages, fmt.Sprintf("encrypt image: %s", encryptedImageID))
+
+		if c.flags.deregisterUnencrypted {
+			image, err := backup.Client.GetImage(ctx, imageID)
+			if err != nil {
+				result.err = fmt.Errorf("failed to deregister unencrypted backup: %s", err.Error())
+				return result
+			}
+			if err := backup.Client.UnshareImage(ctx, image); err != nil {
+				result.err = fmt.Errorf("failed to deregister unencrypted backup: %s", err.Error())
+				return result
+			}
+			if err := backup.Client.DeregisterImages(ctx, []*ec2.Image{image}); err != nil {
+				result.err = fmt.Errorf("failed to deregister unencrypted backup: %s", err.Error())
+				return result
+			}
+			result.messages = append(result.messages, fmt.Sprintf("deregister unencrypted image: %s", imageID))
+			imageID = encryptedImageID
+			result.imageID = imageID
+		}
+	}
+
+	if rotate {
+		if result = archiveImages(ctx, backup, imageID, result); result.err != nil {
+			return result
+		}
+		if result = rotateImages(ctx, backup, imageID, "", result); result.err != nil {
+			return result
+		}
+	}
+
+	if rotate && encryptedImageID != "" && clients.encryptInOtherRegion {
+		encryptBackup := destinationBackup(backup, clients.encrypt, 0)
+		if result = rotateImages(ctx, encryptBackup, encryptedImageID, c.flags.encryptRegion, result); result.err != nil {
+			return result
+		}
+	}
+
+	for _, d := range c.flags.copyDestinations {
+		copyBackup := destinationBackup(backup, clients.copies[d.region], d.generation)
+
+		copiedImageID, err := backup.Copy(ctx, imageID, copyBackup)
+		if err != nil {
+			result.err = fmt.Errorf("failed to copy backup to %s: %s", d.region, err.Error())
+			return result
+		}
+		result.messages = append(result.messages, fmt.Sprintf("copy image to %s: %s", d.region, copiedImageID))
+
+		if rotate {
+			if result = rotateImages(ctx, copyBackup, copiedImageID, d.region, result); result.err != nil {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+// rotate archives and deregisters old backups of the generation management group without creating a backup.
+func (c *CLI) rotate(ctx context.Context, clients *awsClients, backup *Backup, result backupResult) backupResult {
+	if c.flags.dryRun && c.flags.snapshotOnly {
+		return planRotateSnapshotSets(ctx, backup, "", result)
+	}
+
+	if c.flags.dryRun {
+		return c.planRotate(ctx, clients, backup, result, false)
+	}
+
+	if c.flags.snapshotOnly {
+		return rotateSnapshotSets(ctx, backup, "", result)
+	}
+
+	if result = archiveImages(ctx, backup, "", result); result.err != nil {
+		return result
+	}
+	if result = rotateImages(ctx, backup, "", "", result); result.err != nil {
+		return result
+	}
+
+	if c.flags.kmsKeyID != "" && clients.encryptInOtherRegion {
+		encryptBackup := destinationBackup(backup, clients.encrypt, 0)
+		if result = rotateImages(ctx, encryptBackup, "", c.flags.encryptRegion, result); result.err != nil {
+			return result
+		}
+	}
+
+	for _, d := range c.flags.copyDestinations {
+		copyBackup := destinationBackup(backup, clients.copies[d.region], d.generation)
+		if result = rotateImages(ctx, copyBackup, "", d.region, result); result.err != nil {
+			return result
+		}
+	}
+
+	return result
+}
+
+// archiveImages archives old backups when archive generation is set.
+func archiveImages(ctx context.Context, backup *Backup, recentlyImageID string, result backupResult) backupResult {
+	if backup.ArchiveGeneration < 1 {
+		return result
+	}
+
+	archiveImageIDs, err := backup.Archive(ctx, recentlyImageID)
+	if err != nil {
+		result.err = fmt.Errorf("failed to archive: %s", err.Error())
+		return result
+	}
+	result.messages = append(result.messages, fmt.Sprintf("archive images: %s", strings.Join(archiveImageIDs, ", ")))
+
+	return result
+}
+
+// rotateImages deregisters old backups, the region is empty for the backup region.
+// Deregistered backups are reported even if the others failed.
+func rotateImages(ctx context.Context, backup *Backup, recentlyImageID, region string, result backupResult) backupResult {
+	var in string
+	if region != "" {
+		in = " in " + region
+	}
+
+	rotateImageIDs, err := backup.Rotate(ctx, recentlyImageID)
+	if len(rotateImageIDs) > 0 || err == nil {
+		result.messages = append(result.messages, fmt.Sprintf("deregister images%s: %s", in, strings.Join(rotateImageIDs, ", ")))
+	}
+	if err != nil {
+		result.err = fmt.Errorf("failed to rotate%s: %s", in, err.Error())
+	}
+
+	return result
+}
+
+// planBackup prints the backup which would be created and old backups which would be archived and deregistered
+// without changes, and verifies permissions by dry run.
+func (c *CLI) planBackup(ctx context.Context, clients *awsClients, backup *Backup, result backupResult) backupResult {
+	if c.flags.snapshotOnly {
+		return c.planSnapshotSet(ctx, backup, result)
+	}
+
+	createPlan, err := backup.PlanCreate(ctx)
+	if err != nil {
+		result.err = fmt.Errorf("failed to plan backup: %s", err.Error())
+		return result
+	}
+	var tags, volumes []string
+	for _, t := range createPlan.Tags {
+		tags = append(tags, fmt.Sprintf("%s:%s", aws.StringValue(t.Key), aws.StringValue(t.Value)))
+	}
+	for _, d := range createPlan.devices() {
+		volumes = append(volumes, fmt.Sprintf("%s(%s)", d, createPlan.Volumes[d]))
+	}
+	result.messages = append(result.messages, fmt.Sprintf("would create image: %s", createPlan.Name))
+	result.messages = append(result.messages, fmt.Sprintf("would tag image: %s", strings.Join(tags, ", ")))
+	result.messages = append(result.messages, fmt.Sprintf("would include volumes: %s", strings.Join(volumes, ", ")))
+	if len(createPlan.ExcludeDevices) > 0 {
+		result.messages = append(result.messages, fmt.Sprintf("would exclude devices: %s", strings.Join(createPlan.ExcludeDevices, ", ")))
+	}
+	result.messages = append(result.messages, c.sharePlanMessages("image")...)
+
+	if c.flags.kmsKeyID != "" {
+		region := ""
+		if clients.encryptInOtherRegion {
+			region = " in " + c.flags.encryptRegion
+		}
+		result.messages = append(result.messages, fmt.Sprintf("would encrypt image%s with KMS key: %s", region, c.flags.kmsKeyID))
+		result.messages = append(result.messages, c.sharePlanMessages("encrypted image"+region)...)
+		if c.flags.deregisterUnencrypted {
+			result.messages = append(result.messages, "would deregister unencrypted image")
+		}
+	}
+
+	for _, d := range c.flags.copyDestinations {
+		result.messages = append(result.messages, fmt.Sprintf("would copy image to %s", d.region))
+		result.messages = append(result.messages, c.sharePlanMessages("copied image in "+d.region)...)
+	}
+
+	if c.command == commandCreate {
+		return result
+	}
+
+	return c.planRotate(ctx, clients, backup, result, true)
+}
+
+// sharePlanMessages returns the output of sharing of the machine image when -share-with is set.
+func (c *CLI) sharePlanMessages(image string) []string {
+	if len(c.flags.shareWith) < 1 {
+		return nil
+	}
+	return []string{fmt.Sprintf("would share %s with: %s", image, strings.Join(c.flags.shareWith, ", "))}
+}
+
+// planSnapshotSet prints the snapshot-only backup which would be created and old snapshot-only backups
+// which would be deleted without changes.
+func (c *CLI) planSnapshotSet(ctx context.Context, backup *Backup, result backupResult) backupResult {
+	plan, err := backup.PlanCreateSnapshotSet(ctx)
+	if err != nil {
+		result.err = fmt.Errorf("failed to plan backup: %s", err.Error())
+		return result
+	}
+	var tags []string
+	for _, t := range plan.Tags {
+		tags = append(tags, fmt.Sprintf("%s:%s", aws.StringValue(t.Key), aws.StringValue(t.Value)))
+	}
+	result.messages = append(result.messages, fmt.Sprintf("would create snapshot set: %s", plan.SetID))
+	result.messages = append(result.messages, fmt.Sprintf("would tag snapshots: %s", strings.Join(tags, ", ")))
+	if len(plan.ExcludeVolumeIDs) > 0 {
+		result.messages = append(result.messages, fmt.Sprintf("would exclude volumes: %s", strings.Join(plan.ExcludeVolumeIDs, ", ")))
+	}
+
+	if c.command == commandCreate {
+		return result
+	}
+
+	return planRotateSnapshotSets(ctx, backup, plan.SetID, result)
+}
+
+// planRotateSnapshotSets prints old snapshot-only backups which would be deleted without changes,
+// after the recently backup set is created unless its id is empty.
+func planRotateSnapshotSets(ctx context.Context, backup *Backup, recentlySetID string, result backupResult) backupResult {
+	expired, err := backup.PlanRotateSnapshotSets(ctx, recentlySetID)
+	if err != nil {
+		result.err = fmt.Errorf("failed to plan rotation: %s", err.Error())
+		return result
+	}
+	for _, e := range expired {
+		result.messages = append(result.messages, fmt.Sprintf("would delete snapshot set: %s, delete snapshots: %s",
+			e.SetID, strings.Join(e.SnapshotIDs, ", ")))
+	}
+	return result
+}
+
+// planRotate prints old backups which would be archived and deregistered without changes,
+// after a new backup is created when create is true.
+func (c *CLI) planRotate(ctx context.Context, clients *awsClients, backup *Backup, result backupResult, create bool) backupResult {
+	rotatePlan, err := backup.PlanRotate(ctx, create)
+	if err != nil {
+		result.err = fmt.Errorf("failed to plan rotation: %s", err.Error())
+		return result
+	}
+	result.messages = append(result.messages, rotatePlanMessages(rotatePlan, "")...)
+
+	if c.flags.kmsKeyID != "" && clients.encryptInOtherRegion {
+		encryptBackup := destinationBackup(backup, clients.encrypt, 0)
+
+		rotatePlan, err := encryptBackup.PlanRotate(ctx, create)
+		if err != nil {
+			result.err = fmt.Errorf("failed to plan rotation in %s: %s", c.flags.encryptRegion, err.Error())
+			return result
+		}
+		result.messages = append(result.messages, rotatePlanMessages(rotatePlan, " in "+c.flags.encryptRegion)...)
+	}
+
+	for _, d := range c.flags.copyDestinations {
+		copyBackup := destinationBackup(backup, clients.copies[d.region], d.generation)
+
+		rotatePlan, err := copyBackup.PlanRotate(ctx, create)
+		if err != nil {
+			result.err = fmt.Errorf("failed to plan rotation in %s: %s", d.region, err.Error())
+			return result
+		}
+		result.messages = append(result.messages, rotatePlanMessages(rotatePlan, " in "+d.region)...)
+	}
+
+	return result
+}
+
+// rotatePlanMessages returns outputs of machine images which would be archived and deregistered.
+func rotatePlanMessages(plan *RotatePlan, region string) []string {
+	var messages []string
+	for _, image := range plan.Archived {
+		messages = append(messages, fmt.Sprintf("would archive image%s: %s", region, *image.ImageId))
+	}
+	for _, e := range plan.Expired {
+		messages = append(messages, fmt.Sprintf("would deregister image%s: %s (%s), delete snapshots: %s",
+			region, *e.Image.ImageId, e.Reason, strings.Join(imageSnapshots(e.Image), ", ")))
+	}
+	return messages
+}
+
+// backupSnapshots creates a snapshot-only backup of the instance, and rotates old snapshot-only backups when rotate is true.
+func backupSnapshots(ctx context.Context, backup *Backup, rotate bool, result backupResult) backupResult {
+	setID, err := backup.CreateSnapshotSet(ctx)
+	if err != nil {
+		result.err = fmt.Errorf("failed to create backup: %s", err.Error())
+		return result
+	}
+	result.messages = append(result.messages, fmt.Sprintf("create snapshot set: %s", setID))
+
+	if !rotate {
+		return result
+	}
+
+	return rotateSnapshotSets(ctx, backup, setID, result)
+}
+
+// rotateSnapshotSets deletes old snapshot-only backups except the recently backup set,
+// and prints deleted backup sets even when the others failed to delete.
+func rotateSnapshotSets(ctx context.Context, backup *Backup, recentlySetID string, result backupResult) backupResult {
+	rotateSetIDs, err := backup.RotateSnapshotSets(ctx, recentlySetID)
+	if len(rotateSetIDs) > 0 || err == nil {
+		result.messages = append(result.messages, fmt.Sprintf("delete snapshot sets: %s", strings.Join(rotateSetIDs, ", ")))
+	}
+	if err != nil {
+		result.err = fmt.Errorf("failed to rotate: %s", err.Error())
+	}
+
+	return result
 }
@@ -2,8 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/heartbeatsjp/go-create-image-backup/mock"
 )
 
 func TestRun_customTagsFlag(t *testing.T) {
@@ -47,3 +56,813 @@ func TestRun_customTagsFlag(t *testing.T) {
 		})
 	}
 }
+
+func TestRun_instanceFilterFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -instance-filter tag:Env",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -instance-filter tag:Env=",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -instance-filter =prod",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -instance-filter tag:Env=prod,",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -instance-id i-1234567890abcdef0 -instance-filter tag:Env=prod",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -instance-filter tag:Env=prod -concurrency 0",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -instance-filter tag:Env=prod -concurrency -1",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestFilterSliceValue_Set(t *testing.T) {
+	var got filterSliceValue
+	if err := got.Set("tag:Env=prod,tag:Role=web,tag:Role=api"); err != nil {
+		t.Fatal("Set failed: ", err)
+	}
+
+	want := filterSliceValue{
+		{Name: aws.String("tag:Env"), Values: []*string{aws.String("prod")}},
+		{Name: aws.String("tag:Role"), Values: []*string{aws.String("web"), aws.String("api")}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got.String(), want.String())
+	}
+}
+
+func TestRun_copyToRegionFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -copy-to-region us-west-2:",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -copy-to-region us-west-2:0",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -copy-to-region us-west-2:a",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -copy-to-region us-west-2:5:5",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -copy-to-region us-west-2,",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -region ap-northeast-1 -copy-to-region us-west-2,ap-northeast-1:3",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRun_shareWithFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -share-with 123456789012,",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -share-with 12345",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -share-with arn:aws:iam::123456789012:root",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestPrincipalPattern(t *testing.T) {
+	var cases = []string{
+		"123456789012",
+		"arn:aws:organizations::123456789012:organization/o-abcdefghij",
+		"arn:aws:organizations::123456789012:ou/o-abcdefghij/ou-abcd-efghijkl",
+	}
+
+	for _, c := range cases {
+		if !principalPattern.MatchString(c) {
+			t.Errorf("%s does not match", c)
+		}
+	}
+}
+
+func TestRun_encryptFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -encrypt-region us-west-2",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -deregister-unencrypted",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestDurationValue_Set(t *testing.T) {
+	var cases = []struct {
+		val     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{val: "30d", want: 30 * 24 * time.Hour},
+		{val: "12h", want: 12 * time.Hour},
+		{val: "d", wantErr: true},
+		{val: "-1d", wantErr: true},
+		{val: "30", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.val, func(t *testing.T) {
+			var got durationValue
+			err := got.Set(c.val)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error %t", err, c.wantErr)
+			}
+			if time.Duration(got) != c.want {
+				t.Fatalf("got %s, want %s", time.Duration(got), c.want)
+			}
+		})
+	}
+}
+
+func TestRun_deprecateFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -deprecate-after 1w",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -deprecate-after 30d -backup-interval 24h",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -snapshot-only -deprecate-after 30d",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRun_templateFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -name-template {{.Name",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -name-template {{.Unknown}}",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -custom-tags Env:prod -description-template {{.Tags.Role}}",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -snapshot-only -name-template {{.Name}}",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRun_propagateTagsFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -propagate-tags-regex ^(team",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -propagate-tags CostCenter,",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -snapshot-only -propagate-tags CostCenter",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRun_tagSchemaFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -tag-keys Unknown:Key",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup migrate-tags -tag-keys Service:",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup migrate-tags -from-tag-keys Unknown:Key",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -tag-keys Service:Name",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -tag-keys Service:Owner,Name:Owner",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -tag-prefix aws:",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup migrate-tags -from-tag-keys Service:Name",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRun_keepFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -keep-daily -1",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -keep-daily 7 -snapshot-only",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -keep-weekly 4 -archive-generation 2",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -keep-daily 7 -backup-interval 24h",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -keep-monthly 12 -rotate-deprecated",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -min-generations 3",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -max-age 30d -min-generations -1",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -max-age 30d -keep-daily 7",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -max-age 30d -backup-interval 24h",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -max-age 30d -min-generations 7 -rotate-deprecated",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRun_subcommandFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -all",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup create -name web",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup prune -all -instance-id i-1234567890abcdef0",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup rotate -name web -rotate-by-instance-id",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup list -snapshot-only",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup list -all",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup list -output yaml",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup rotate -output json",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup create -since 2006-01-02",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup list -since 2006-01-03 -until 2006-01-02T15:04:05Z",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup list -since yesterday",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup list -instance-filter tag:Backup=true",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup list -share-with 123456789012 -backup-generation 3",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup list -copy-to-region us-west-2",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup rotate -share-with 123456789012",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup prune -exclude-devices /dev/sdf",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRun_restoreFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup restore",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup restore -image latest",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup restore -image ami-1234567890abcdef0 -before 2006-01-02",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup restore -image latest -name web -before yesterday",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup restore-volume -image ami-1234567890abcdef0 -availability-zone ap-northeast-1a",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup restore-volume -image ami-1234567890abcdef0 -device /dev/sdf",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup restore-volume -image ami-1234567890abcdef0 -device /dev/sdf -availability-zone ap-northeast-1a -attach-device /dev/sdg",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup restore-volume -image latest -device /dev/sdf -instance-id i-1234567890abcdef0",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestRun_configFlag(t *testing.T) {
+	valid := writeConfig(t, "jobs:\n  - name: web\n    options: {instance-id: i-1234567890abcdef0}\n")
+	defer os.Remove(valid)
+	unknownOption := writeConfig(t, "jobs:\n  - name: web\n    options: {instance-ids: i-1234567890abcdef0}\n")
+	defer os.Remove(unknownOption)
+	invalidJob := writeConfig(t, "jobs:\n  - name: web\n  - name: db\n    options: {instance-id: i-1234567890abcdef0, instance-filter: {tag:Role: db}}\n")
+	defer os.Remove(invalidJob)
+	versionOption := writeConfig(t, "jobs:\n  - name: web\n    options: {version: true}\n")
+	defer os.Remove(versionOption)
+
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -config " + valid + " -instance-id i-1234567890abcdef0",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup create -config " + valid,
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -config " + valid + ".notfound",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -config " + unknownOption,
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -config " + invalidJob,
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -config " + versionOption,
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+			cli := &CLI{outStream: outStream, errStream: errStream}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+			if outStream.Len() > 0 {
+				t.Errorf("no job should run, got output %q", outStream.String())
+			}
+		})
+	}
+}
+
+func TestCLIRotate(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	images := []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("web", "")).Return(images, nil)
+	mockAWSClient.EXPECT().UnshareImage(context.TODO(), gomock.Any()).Return(nil).AnyTimes()
+	mockAWSClient.EXPECT().DeregisterImages(context.TODO(), images[:1]).Return(nil)
+
+	cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer), command: commandRotate}
+	cli.flags.generation = 1
+	clients := &awsClients{backup: mockAWSClient, encrypt: mockAWSClient}
+	backup := cli.newBackup(clients, "")
+	backup.Name = "web"
+
+	result := cli.rotate(context.TODO(), clients, backup, backupResult{})
+	if result.err != nil {
+		t.Fatal("rotate failed: ", result.err)
+	}
+
+	want := []string{"deregister images: ami-1234567890abcdef0"}
+	if !reflect.DeepEqual(result.messages, want) {
+		t.Fatalf("got %s, want %s", result.messages, want)
+	}
+}
+
+func TestRun_groupByFlag(t *testing.T) {
+	var cases = []struct {
+		args string
+		want int
+	}{
+		{
+			args: "go-create-image-backup -group-by Service,Environment",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -group-by instance-id",
+			want: ExitCodeFlagParseError,
+		},
+		{
+			args: "go-create-image-backup -rotate-by-instance-id -group-by InstanceId",
+			want: ExitCodeFlagParseError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.args, func(t *testing.T) {
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+			got := cli.Run(strings.Split(c.args, " "))
+			if c.want != got {
+				t.Errorf("want %d, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestCLIBackup_dryRun(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstanceName(context.TODO(), "i-1234567890abcdef0").Return("web", nil)
+	mockAWSClient.EXPECT().ImageNameExists(context.TODO(), imageNameMatcher("web")).Return(false, nil)
+	mockAWSClient.EXPECT().GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Volume{
+		{
+			VolumeId:    aws.String("vol-1234567890abcdef0"),
+			Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/xvda")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().DryRunCreateImage(context.TODO(), "i-1234567890abcdef0", imageNameMatcher("web"), gomock.Any(), gomock.Nil()).Return(nil)
+
+	cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer), command: commandCreate}
+	cli.flags.dryRun = true
+	cli.flags.kmsKeyID = "alias/backup"
+	cli.flags.encryptRegion = "us-east-1"
+	cli.flags.deregisterUnencrypted = true
+	cli.flags.copyDestinations = []copyDestination{{region: "us-west-2"}}
+	cli.flags.shareWith = []string{"123456789012"}
+	clients := &awsClients{
+		backup:               mockAWSClient,
+		encrypt:              mockAWSClient,
+		encryptInOtherRegion: true,
+		copies:               map[string]AWS{"us-west-2": mockAWSClient},
+	}
+
+	result := cli.backup(context.TODO(), clients, "i-1234567890abcdef0")
+	if result.err != nil {
+		t.Fatal("backup failed: ", result.err)
+	}
+
+	want := []string{
+		"would share image with: 123456789012",
+		"would encrypt image in us-east-1 with KMS key: alias/backup",
+		"would share encrypted image in us-east-1 with: 123456789012",
+		"would deregister unencrypted image",
+		"would copy image to us-west-2",
+		"would share copied image in us-west-2 with: 123456789012",
+	}
+	if len(result.messages) != 3+len(want) || !reflect.DeepEqual(result.messages[3:], want) {
+		t.Fatalf("got %s, want %s after the image", result.messages, want)
+	}
+}
+
+func TestCLIBackup_dryRunSnapshotOnly(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstanceName(context.TODO(), "i-1234567890abcdef0").Return("web", nil)
+	mockAWSClient.EXPECT().DryRunCreateSnapshots(context.TODO(), "i-1234567890abcdef0", gomock.Nil(), gomock.Any()).Return(nil)
+	mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), groupTags("web", ""), []string{"BackupSetId"}).Return([]*ec2.Snapshot{
+		{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+			StartTime:  aws.Time(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)),
+			Tags:       []*ec2.Tag{{Key: aws.String("BackupSetId"), Value: aws.String("set0")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().DryRunDeleteSnapshots(context.TODO(), []string{"snap-1234567890abcdef0"}).Return(nil)
+
+	cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+	cli.flags.dryRun = true
+	cli.flags.snapshotOnly = true
+	cli.flags.generation = 1
+	clients := &awsClients{backup: mockAWSClient, encrypt: mockAWSClient}
+
+	result := cli.backup(context.TODO(), clients, "i-1234567890abcdef0")
+	if result.err != nil {
+		t.Fatal("backup failed: ", result.err)
+	}
+
+	if len(result.messages) != 3 ||
+		!strings.HasPrefix(result.messages[0], "would create snapshot set: i-1234567890abcdef0-") ||
+		!strings.HasPrefix(result.messages[1], "would tag snapshots: BackupType:auto, Name:web, Service:, InstanceId:i-1234567890abcdef0, BackupSetId:") ||
+		result.messages[2] != "would delete snapshot set: set0, delete snapshots: snap-1234567890abcdef0" {
+		t.Fatalf("unexpected messages: %s", result.messages)
+	}
+}
+
+func TestCLIBackup_createSnapshotOnly(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// create command does not rotate old snapshot sets
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstanceName(context.TODO(), "i-1234567890abcdef0").Return("web", nil)
+	mockAWSClient.EXPECT().CreateSnapshots(context.TODO(), "i-1234567890abcdef0", gomock.Nil(), gomock.Any()).Return([]string{"snap-1234567890abcdef0"}, nil)
+
+	cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer), command: commandCreate}
+	cli.flags.snapshotOnly = true
+	cli.flags.generation = 1
+	clients := &awsClients{backup: mockAWSClient, encrypt: mockAWSClient}
+
+	result := cli.backup(context.TODO(), clients, "i-1234567890abcdef0")
+	if result.err != nil {
+		t.Fatal("backup failed: ", result.err)
+	}
+
+	if len(result.messages) != 1 || !strings.HasPrefix(result.messages[0], "create snapshot set: i-1234567890abcdef0-") {
+		t.Fatalf("unexpected messages: %s", result.messages)
+	}
+}
+
+func TestCLITargetBackups_noInstanceName(t *testing.T) {
+	var cases = []struct {
+		name    string
+		images  []*ec2.Image
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "found",
+			images: []*ec2.Image{
+				{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), Tags: []*ec2.Tag{
+					{Key: aws.String("Name"), Value: aws.String("web")},
+					{Key: aws.String("Service"), Value: aws.String("")},
+				}},
+			},
+			want: "web",
+		},
+		{
+			name:    "not found",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			// Name tag of terminated instances is not found
+			mockAWSClient := mock.NewMockAWS(mockCtrl)
+			mockAWSClient.EXPECT().GetInstanceName(context.TODO(), "i-1234567890abcdef0").Return("i-1234567890abcdef0", nil)
+			mockAWSClient.EXPECT().GetImages(context.TODO(), []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+			}).Return(c.images, nil)
+
+			cli := &CLI{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer), command: commandRotate}
+			cli.flags.instanceID = "i-1234567890abcdef0"
+			cli.flags.tagSchema.backupTypeValue = DefaultBackupTypeValue
+			clients := &awsClients{backup: mockAWSClient, encrypt: mockAWSClient}
+
+			got, err := cli.targetBackups(context.TODO(), clients)
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("targetBackups should fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("targetBackups failed: ", err)
+			}
+			if len(got) != 1 || got[0].Name != c.want {
+				t.Fatalf("got %v, want %s", got, c.want)
+			}
+		})
+	}
+}
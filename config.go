@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is a file of backup jobs, which is written in YAML or JSON.
+type Config struct {
+	Jobs []ConfigJob `yaml:"jobs"`
+}
+
+// ConfigJob is a backup job. The keys of options are the names of options of the command line without hyphen,
+// and lists and maps of values are joined like the values of the command line such as custom-tags: {Env: prod}.
+type ConfigJob struct {
+	Name string `yaml:"name"`
+	// Command is the subcommand of the job, empty means creating a backup and rotating old backups.
+	Command string                 `yaml:"command"`
+	Options map[string]interface{} `yaml:"options"`
+}
+
+// configOnlyOptions are options which can not be used in jobs.
+var configOnlyOptions = []string{"config", "version", "v"}
+
+// LoadConfig reads the config file, and returns error when the file has unknown keys or invalid jobs.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is also parsed as YAML
+	var config Config
+	if err := yaml.UnmarshalStrict(b, &config); err != nil {
+		return nil, err
+	}
+
+	if len(config.Jobs) < 1 {
+		return nil, fmt.Errorf("no job is defined in %s", path)
+	}
+
+	names := make(map[string]bool)
+	for i, j := range config.Jobs {
+		if j.Name != "" {
+			if names[j.Name] {
+				return nil, fmt.Errorf("job %s is defined more than once", j.Name)
+			}
+			names[j.Name] = true
+		}
+		switch j.Command {
+		case "", commandCreate, commandRotate, commandPrune, commandList:
+		default:
+			return nil, fmt.Errorf("unknown command of job %s: %s", j.label(i), j.Command)
+		}
+	}
+
+	return &config, nil
+}
+
+// label returns the name of the job, or the position of the job when it does not have the name.
+func (j *ConfigJob) label(i int) string {
+	if j.Name != "" {
+		return j.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// command returns the subcommand of the job, prune is the alias of rotate.
+func (j *ConfigJob) command() string {
+	if j.Command == commandPrune {
+		return commandRotate
+	}
+	return j.Command
+}
+
+// args returns arguments of the command line of the options sorted by the names.
+func (j *ConfigJob) args() ([]string, error) {
+	var keys []string
+	for k := range j.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		for _, o := range configOnlyOptions {
+			if k == o {
+				return nil, fmt.Errorf("%s can not be used in jobs", k)
+			}
+		}
+		value, err := optionValue(k, j.Options[k])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", k, value))
+	}
+	return args, nil
+}
+
+// optionValue returns the value of the command line for the value of the option.
+// Keys and values of maps are joined by "=" for instance-filter and by ":" for other options like tags.
+func optionValue(key string, v interface{}) (string, error) {
+	switch value := v.(type) {
+	case nil:
+		return "", fmt.Errorf("value of %s is empty", key)
+	case []interface{}:
+		var values []string
+		for _, e := range value {
+			s, err := optionValue(key, e)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, s)
+		}
+		return strings.Join(values, ","), nil
+	case map[interface{}]interface{}:
+		sep := ":"
+		if key == "instance-filter" {
+			sep = "="
+		}
+		var pairs []string
+		for k, e := range value {
+			// multiple values of a filter name match any of the values
+			elems, ok := e.([]interface{})
+			if !ok {
+				elems = []interface{}{e}
+			}
+			for _, elem := range elems {
+				s, err := optionValue(key, elem)
+				if err != nil {
+					return "", err
+				}
+				pairs = append(pairs, fmt.Sprintf("%v%s%s", k, sep, s))
+			}
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	}
+	return fmt.Sprint(v), nil
+}
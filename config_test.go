@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// writeConfig writes the content to a temporary file and returns the path.
+func writeConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadConfig(t *testing.T) {
+	var cases = []struct {
+		name    string
+		content string
+		want    [][]string
+	}{
+		{
+			name: "yaml",
+			content: `
+jobs:
+  - name: web
+    options:
+      instance-filter:
+        tag:Role: [web, api]
+        instance-state-name: running
+      region: ap-northeast-1
+      service-tag: daily
+      backup-generation: 7
+      custom-tags: {Env: prod, Team: ops}
+      copy-to-region: [us-west-2:3, eu-west-1]
+      mail-to: ops@example.com
+  - command: prune
+    options:
+      all: true
+      max-age: 30d
+`,
+			want: [][]string{
+				{
+					"-backup-generation=7",
+					"-copy-to-region=us-west-2:3,eu-west-1",
+					"-custom-tags=Env:prod,Team:ops",
+					"-instance-filter=instance-state-name=running,tag:Role=api,tag:Role=web",
+					"-mail-to=ops@example.com",
+					"-region=ap-northeast-1",
+					"-service-tag=daily",
+				},
+				{
+					"-all=true",
+					"-max-age=30d",
+				},
+			},
+		},
+		{
+			name:    "json",
+			content: `{"jobs": [{"name": "db", "options": {"instance-id": "i-1234567890abcdef0", "keep-daily": 7, "keep-weekly": 4}}]}`,
+			want: [][]string{
+				{
+					"-instance-id=i-1234567890abcdef0",
+					"-keep-daily=7",
+					"-keep-weekly=4",
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := writeConfig(t, c.content)
+			defer os.Remove(path)
+
+			config, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal("LoadConfig failed: ", err)
+			}
+
+			var got [][]string
+			for _, j := range config.Jobs {
+				args, err := j.args()
+				if err != nil {
+					t.Fatal("args failed: ", err)
+				}
+				got = append(got, args)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	var cases = []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "no jobs",
+			content: `jobs: []`,
+		},
+		{
+			name:    "unknown key",
+			content: "jobs:\n  - name: web\n    option: {region: ap-northeast-1}\n",
+		},
+		{
+			name:    "unknown command",
+			content: "jobs:\n  - name: web\n    command: backup\n",
+		},
+		{
+			name:    "duplicate name",
+			content: "jobs:\n  - name: web\n  - name: web\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := writeConfig(t, c.content)
+			defer os.Remove(path)
+
+			if _, err := LoadConfig(path); err == nil {
+				t.Fatal("LoadConfig should fail")
+			}
+		})
+	}
+}
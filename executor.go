@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// executor runs backups of multiple instances concurrently.
+type executor struct {
+	// workers is the maximum number of backups which run at the same time.
+	workers int
+	// timeout is the deadline of backup per instance, zero means no deadline.
+	timeout time.Duration
+}
+
+// run runs fn for each instance id and returns results in the same order as instance ids.
+func (e *executor) run(ctx context.Context, instanceIDs []string, fn func(ctx context.Context, instanceID string) backupResult) []backupResult {
+	workers := e.workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]backupResult, len(instanceIDs))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = e.runOne(ctx, instanceIDs[i], fn)
+			}
+		}()
+	}
+
+	for i := range instanceIDs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+func (e *executor) runOne(ctx context.Context, instanceID string, fn func(ctx context.Context, instanceID string) backupResult) backupResult {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return backupResult{instanceID: instanceID, err: err}
+	}
+
+	return fn(ctx, instanceID)
+}
+
+// fleetError is an aggregated error of backups for multiple instances.
+type fleetError struct {
+	failures []backupResult
+	total    int
+}
+
+func (e *fleetError) Error() string {
+	var errList []string
+	for _, r := range e.failures {
+		errList = append(errList, fmt.Sprintf("%s: %s", r.instanceID, r.err.Error()))
+	}
+	return fmt.Sprintf("failed to backup %d of %d instances: %s", len(e.failures), e.total, strings.Join(errList, ", "))
+}
+
+// newFleetError returns fleetError when any of results failed, otherwise returns nil.
+func newFleetError(results []backupResult) error {
+	var failures []backupResult
+	for _, r := range results {
+		if r.err != nil {
+			failures = append(failures, r)
+		}
+	}
+	if len(failures) < 1 {
+		return nil
+	}
+	return &fleetError{failures: failures, total: len(results)}
+}
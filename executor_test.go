@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecutorRun(t *testing.T) {
+	instanceIDs := []string{"i-1234567890abcdef0", "i-1234567890abcdef1", "i-1234567890abcdef2", "i-1234567890abcdef3"}
+
+	var mu sync.Mutex
+	var running, maxRunning int
+
+	e := &executor{workers: 2}
+	got := e.run(context.TODO(), instanceIDs, func(ctx context.Context, instanceID string) backupResult {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return backupResult{instanceID: instanceID, imageID: "ami-" + instanceID[2:]}
+	})
+
+	for i, r := range got {
+		if r.instanceID != instanceIDs[i] {
+			t.Fatalf("got %s, want %s", r.instanceID, instanceIDs[i])
+		}
+	}
+	if maxRunning > 2 {
+		t.Fatalf("got %d workers running at the same time, want at most 2", maxRunning)
+	}
+}
+
+func TestExecutorRun_Timeout(t *testing.T) {
+	e := &executor{workers: 1, timeout: 10 * time.Millisecond}
+	got := e.run(context.TODO(), []string{"i-1234567890abcdef0"}, func(ctx context.Context, instanceID string) backupResult {
+		<-ctx.Done()
+		return backupResult{instanceID: instanceID, err: ctx.Err()}
+	})
+
+	if got[0].err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", got[0].err, context.DeadlineExceeded)
+	}
+}
+
+func TestNewFleetError(t *testing.T) {
+	results := []backupResult{
+		{instanceID: "i-1234567890abcdef0", imageID: "ami-1234567890abcdef0"},
+		{instanceID: "i-1234567890abcdef1", err: errors.New("failed to create backup: error")},
+	}
+
+	got := newFleetError(results)
+
+	want := "failed to backup 1 of 2 instances: i-1234567890abcdef1: failed to create backup: error"
+	if got.Error() != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestNewFleetError_NoFailure(t *testing.T) {
+	results := []backupResult{
+		{instanceID: "i-1234567890abcdef0", imageID: "ami-1234567890abcdef0"},
+	}
+
+	if got := newFleetError(results); got != nil {
+		t.Fatalf("got %s, want nil", got)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// copyImageDescriptionPattern matches description of snapshots which are created by CopyImage.
+var copyImageDescriptionPattern = regexp.MustCompile(`^Copied for DestinationAmi (ami-[0-9a-f]+)`)
+
+// OrphanedSnapshot is a snapshot of backup whose machine image no longer exists.
+type OrphanedSnapshot struct {
+	Snapshot *ec2.Snapshot
+	ImageID  string
+}
+
+// BrokenImage is a machine image of backup whose snapshots are partially missing.
+type BrokenImage struct {
+	Image              *ec2.Image
+	MissingSnapshotIDs []string
+}
+
+// GarbageCollection finds snapshots of backups which are left by failed rotations or manual deregistrations.
+type GarbageCollection struct {
+	Schema TagSchema
+	Client AWS
+}
+
+// Find returns orphaned snapshots whose machine image no longer exists, and machine images whose snapshots
+// are partially missing. The machine image of a snapshot is found by ImageId tag or description of the snapshot,
+// and snapshots whose machine image is unknown and snapshots of snapshot-only backups are not orphaned.
+func (g *GarbageCollection) Find(ctx context.Context) ([]OrphanedSnapshot, []BrokenImage, error) {
+	backupType := []*ec2.Tag{g.Schema.Tag(TagBackupType, g.Schema.BackupType())}
+
+	images, err := g.Client.GetImages(ctx, backupType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshots, err := g.Client.GetTaggedSnapshots(ctx, backupType, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, image := range images {
+		for _, id := range imageSnapshots(image) {
+			referenced[id] = true
+		}
+	}
+
+	// machine images may exist without tags of backup when tagging failed, so that these are confirmed by ids
+	var candidates []OrphanedSnapshot
+	var parentIDs []string
+	parents := make(map[string]bool)
+	for _, s := range snapshots {
+		if referenced[*s.SnapshotId] || hasTag(s.Tags, g.Schema.Key(TagBackupSetID)) {
+			continue
+		}
+		imageID := g.snapshotImageID(s)
+		if imageID == "" {
+			continue
+		}
+		candidates = append(candidates, OrphanedSnapshot{Snapshot: s, ImageID: imageID})
+		if !parents[imageID] {
+			parents[imageID] = true
+			parentIDs = append(parentIDs, imageID)
+		}
+	}
+
+	var orphaned []OrphanedSnapshot
+	if len(parentIDs) > 0 {
+		existingImages, err := g.Client.FindImages(ctx, parentIDs)
+		if err != nil {
+			return nil, nil, err
+		}
+		exists := make(map[string]bool)
+		for _, image := range existingImages {
+			exists[*image.ImageId] = true
+		}
+		for _, c := range candidates {
+			if !exists[c.ImageID] {
+				orphaned = append(orphaned, c)
+			}
+		}
+	}
+
+	broken, err := g.brokenImages(ctx, images, snapshots)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return orphaned, broken, nil
+}
+
+// snapshotImageID returns the id of machine image of the snapshot, or empty when it is unknown.
+func (g *GarbageCollection) snapshotImageID(s *ec2.Snapshot) string {
+	if id := tagValue(s.Tags, g.Schema.Key(TagImageID)); id != "" {
+		return id
+	}
+	description := aws.StringValue(s.Description)
+	if m := createImageDescriptionPattern.FindStringSubmatch(description); m != nil {
+		return m[2]
+	}
+	if m := copyImageDescriptionPattern.FindStringSubmatch(description); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// brokenImages returns available machine images which have snapshots which no longer exist.
+func (g *GarbageCollection) brokenImages(ctx context.Context, images []*ec2.Image, snapshots []*ec2.Snapshot) ([]BrokenImage, error) {
+	found := make(map[string]bool)
+	for _, s := range snapshots {
+		found[*s.SnapshotId] = true
+	}
+
+	// snapshots may exist without tags of backup when tagging failed
+	var unknownIDs []string
+	for _, image := range images {
+		if aws.StringValue(image.State) != "available" {
+			continue
+		}
+		for _, id := range imageSnapshots(image) {
+			if !found[id] {
+				unknownIDs = append(unknownIDs, id)
+			}
+		}
+	}
+	if len(unknownIDs) < 1 {
+		return nil, nil
+	}
+
+	existingSnapshots, err := g.Client.FindSnapshots(ctx, unknownIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range existingSnapshots {
+		found[*s.SnapshotId] = true
+	}
+
+	var broken []BrokenImage
+	for _, image := range images {
+		if aws.StringValue(image.State) != "available" {
+			continue
+		}
+		var missing []string
+		for _, id := range imageSnapshots(image) {
+			if !found[id] {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			broken = append(broken, BrokenImage{Image: image, MissingSnapshotIDs: missing})
+		}
+	}
+	return broken, nil
+}
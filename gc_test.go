@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/heartbeatsjp/go-create-image-backup/mock"
+)
+
+func TestGarbageCollectionFind(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	backupType := []*ec2.Tag{{Key: aws.String("BackupType"), Value: aws.String("auto")}}
+	images := []*ec2.Image{
+		{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+			State:   aws.String("available"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")}},
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef1")}},
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef2")}},
+			},
+		},
+	}
+	snapshots := []*ec2.Snapshot{
+		{SnapshotId: aws.String("snap-1234567890abcdef0"), Tags: backupType},
+		{
+			SnapshotId: aws.String("snap-1234567890abcdef3"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef1")},
+			},
+		},
+		{
+			SnapshotId:  aws.String("snap-1234567890abcdef4"),
+			Description: aws.String("Created by CreateImage(i-1234567890abcdef0) for ami-1234567890abcdef2"),
+			Tags:        backupType,
+		},
+		{
+			SnapshotId: aws.String("snap-1234567890abcdef5"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("BackupSetId"), Value: aws.String("i-1234567890abcdef0-20060102150405")},
+			},
+		},
+		{SnapshotId: aws.String("snap-1234567890abcdef6"), Description: aws.String("unknown"), Tags: backupType},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), backupType).Return(images, nil)
+	mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), backupType, gomock.Nil()).Return(snapshots, nil)
+	mockAWSClient.EXPECT().FindImages(context.TODO(), []string{"ami-1234567890abcdef1", "ami-1234567890abcdef2"}).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef2")},
+	}, nil)
+	mockAWSClient.EXPECT().FindSnapshots(context.TODO(), []string{"snap-1234567890abcdef1", "snap-1234567890abcdef2"}).Return([]*ec2.Snapshot{
+		{SnapshotId: aws.String("snap-1234567890abcdef1")},
+	}, nil)
+
+	gc := &GarbageCollection{Client: mockAWSClient}
+
+	orphaned, broken, err := gc.Find(context.TODO())
+	if err != nil {
+		t.Fatal("Find failed: ", err)
+	}
+
+	wantOrphaned := []OrphanedSnapshot{{Snapshot: snapshots[1], ImageID: "ami-1234567890abcdef1"}}
+	if !reflect.DeepEqual(orphaned, wantOrphaned) {
+		t.Fatalf("got %v, want %v", orphaned, wantOrphaned)
+	}
+	wantBroken := []BrokenImage{{Image: images[0], MissingSnapshotIDs: []string{"snap-1234567890abcdef2"}}}
+	if !reflect.DeepEqual(broken, wantBroken) {
+		t.Fatalf("got %v, want %v", broken, wantBroken)
+	}
+}
+
+func TestCLIGC(t *testing.T) {
+	var cases = []struct {
+		name   string
+		answer string
+		yes    bool
+		delete bool
+	}{
+		{name: "confirmed", answer: "y\n", delete: true},
+		{name: "canceled", answer: "\n", delete: false},
+		{name: "yes", answer: "", yes: true, delete: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			backupType := []*ec2.Tag{{Key: aws.String("BackupType"), Value: aws.String("auto")}}
+			mockAWSClient := mock.NewMockAWS(mockCtrl)
+			mockAWSClient.EXPECT().GetImages(context.TODO(), backupType).Return(nil, nil)
+			mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), backupType, gomock.Nil()).Return([]*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("snap-1234567890abcdef0"),
+					StartTime:  aws.Time(time.Now().Add(-48 * time.Hour)),
+					VolumeSize: aws.Int64(8),
+					Tags: []*ec2.Tag{
+						{Key: aws.String("BackupType"), Value: aws.String("auto")},
+						{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
+					},
+				},
+			}, nil)
+			mockAWSClient.EXPECT().FindImages(context.TODO(), []string{"ami-1234567890abcdef0"}).Return(nil, nil)
+			if c.delete {
+				mockAWSClient.EXPECT().DeleteSnapshots(context.TODO(), []string{"snap-1234567890abcdef0"}).Return(nil)
+			}
+
+			outStream := new(bytes.Buffer)
+			cli := &CLI{outStream: outStream, errStream: new(bytes.Buffer), inStream: strings.NewReader(c.answer)}
+			if got := cli.gc(context.TODO(), &GarbageCollection{Client: mockAWSClient}, c.yes); got != ExitCodeOK {
+				t.Fatalf("want %d, got %d", ExitCodeOK, got)
+			}
+
+			want := "orphaned snapshot: snap-1234567890abcdef0 (image: ami-1234567890abcdef0, size: 8 GiB, age: 2 days)"
+			if !strings.Contains(outStream.String(), want) {
+				t.Fatalf("got %s, want %s", outStream.String(), want)
+			}
+		})
+	}
+}
+
+func TestCLIGC_PartialFailure(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	snapshot := func(snapshotID string) *ec2.Snapshot {
+		return &ec2.Snapshot{
+			SnapshotId: aws.String(snapshotID),
+			StartTime:  aws.Time(time.Now().Add(-48 * time.Hour)),
+			VolumeSize: aws.Int64(8),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("ImageId"), Value: aws.String("ami-1234567890abcdef0")},
+			},
+		}
+	}
+
+	backupType := []*ec2.Tag{{Key: aws.String("BackupType"), Value: aws.String("auto")}}
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), backupType).Return(nil, nil)
+	mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), backupType, gomock.Nil()).Return([]*ec2.Snapshot{
+		snapshot("snap-1234567890abcdef0"),
+		snapshot("snap-1234567890abcdef1"),
+	}, nil)
+	mockAWSClient.EXPECT().FindImages(context.TODO(), []string{"ami-1234567890abcdef0"}).Return(nil, nil)
+	mockAWSClient.EXPECT().DeleteSnapshots(context.TODO(), []string{"snap-1234567890abcdef0", "snap-1234567890abcdef1"}).Return(&deleteSnapshotsError{
+		failures: []resourceError{{resourceID: "snap-1234567890abcdef1", err: errors.New("unauthorized")}},
+		total:    2,
+	})
+
+	outStream := new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: new(bytes.Buffer)}
+	if got := cli.gc(context.TODO(), &GarbageCollection{Client: mockAWSClient}, true); got != ExitCodeAWSError {
+		t.Fatalf("want %d, got %d", ExitCodeAWSError, got)
+	}
+
+	// snapshots which are deleted are printed even when the others failed
+	want := "delete snapshots: snap-1234567890abcdef0\n"
+	if !strings.Contains(outStream.String(), want) {
+		t.Fatalf("got %s, want %s", outStream.String(), want)
+	}
+}
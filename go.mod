@@ -3,11 +3,11 @@ module github.com/heartbeatsjp/go-create-image-backup
 go 1.13
 
 require (
-	github.com/aws/aws-sdk-go v1.15.71
+	github.com/aws/aws-sdk-go v1.55.8
 	github.com/golang/mock v1.1.1
-	github.com/jmespath/go-jmespath v0.0.0-20160202185014-0b12d6b521d8
 	github.com/pkg/errors v0.8.0
-	golang.org/x/net v0.0.0-20181108082009-03003ca0c849
-	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc
+	golang.org/x/net v0.0.0-20181108082009-03003ca0c849 // indirect
+	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/gomail.v2 v2.0.0-20150902115704-41f357289737
+	gopkg.in/yaml.v2 v2.2.8
 )
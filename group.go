@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// BackupGroups returns backups of all generation management groups of existing machine images.
+// The backups are copies of base whose values of group keys are taken from tags of the machine images,
+// and machine images which do not have all tags of group keys are not grouped.
+// Groups are selected by values of group keys of base such as Service and custom tags unless the values are empty.
+func BackupGroups(ctx context.Context, base *Backup) ([]*Backup, error) {
+	images, err := base.Client.GetImages(ctx, []*ec2.Tag{base.Schema.Tag(TagBackupType, base.Schema.BackupType())})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*Backup)
+	for _, image := range images {
+		if b, ok := imageGroup(base, image); ok && base.selectsGroup(b) {
+			groups[b.GroupLabel()] = b
+		}
+	}
+
+	var labels []string
+	for label := range groups {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var backups []*Backup
+	for _, label := range labels {
+		backups = append(backups, groups[label])
+	}
+	return backups, nil
+}
+
+// InstanceBackupGroup returns the backup of the generation management group of the latest machine image
+// which was created from the instance of base, so that backups of terminated instances whose tags are lost can be rotated.
+// It returns false when machine images of the instance are not found.
+func InstanceBackupGroup(ctx context.Context, base *Backup) (*Backup, bool, error) {
+	images, err := base.Client.GetImages(ctx, []*ec2.Tag{
+		base.Schema.Tag(TagBackupType, base.Schema.BackupType()),
+		base.Schema.Tag(TagInstanceID, base.InstanceID),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return convertDate(aws.StringValue(images[i].CreationDate)).After(convertDate(aws.StringValue(images[j].CreationDate)))
+	})
+	for _, image := range images {
+		if b, ok := imageGroup(base, image); ok && base.selectsGroup(b) {
+			return b, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// imageGroup returns a copy of base whose values of group keys are taken from tags of the machine image,
+// or false when the machine image does not have all tags of group keys.
+func imageGroup(base *Backup, image *ec2.Image) (*Backup, bool) {
+	b := *base
+	b.CustomTags = nil
+	for _, key := range base.groupBy() {
+		tagKey := key
+		if key == TagName || key == TagService || key == TagInstanceID {
+			tagKey = base.Schema.Key(key)
+		}
+		if !hasTag(image.Tags, tagKey) {
+			return nil, false
+		}
+		value := tagValue(image.Tags, tagKey)
+		switch key {
+		case TagName:
+			b.Name = value
+		case TagService:
+			b.Service = value
+		case TagInstanceID:
+			b.InstanceID = value
+		default:
+			b.CustomTags = append(b.CustomTags, Tag{Key: key, Value: value})
+		}
+	}
+	return &b, true
+}
+
+// selectsGroup returns true when values of group keys of the group equal non-empty values of b.
+func (b *Backup) selectsGroup(group *Backup) bool {
+	for _, key := range b.groupBy() {
+		var value, groupValue string
+		switch key {
+		case TagName:
+			value, groupValue = b.Name, group.Name
+		case TagService:
+			value, groupValue = b.Service, group.Service
+		case TagInstanceID:
+			value, groupValue = b.InstanceID, group.InstanceID
+		default:
+			value, groupValue = customTagValue(b.CustomTags, key), customTagValue(group.CustomTags, key)
+		}
+		if value != "" && value != groupValue {
+			return false
+		}
+	}
+	return true
+}
+
+// customTagValue returns the value of the custom tag of the key, or empty when it is not found.
+func customTagValue(tags []Tag, key string) string {
+	for _, t := range tags {
+		if t.Key == key {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+// GroupLabel returns keys and values of tags which identify the generation management group like Name=web,Service=daily.
+func (b *Backup) GroupLabel() string {
+	var labels []string
+	for _, t := range b.groupTags()[1:] {
+		labels = append(labels, fmt.Sprintf("%s=%s", aws.StringValue(t.Key), aws.StringValue(t.Value)))
+	}
+	return strings.Join(labels, ",")
+}
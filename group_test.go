@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/heartbeatsjp/go-create-image-backup/mock"
+)
+
+func TestBackupGroups(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), []*ec2.Tag{{Key: aws.String("BackupType"), Value: aws.String("auto")}}).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), Tags: []*ec2.Tag{
+			{Key: aws.String("BackupType"), Value: aws.String("auto")},
+			{Key: aws.String("Name"), Value: aws.String("web")},
+			{Key: aws.String("Service"), Value: aws.String("daily")},
+			{Key: aws.String("Env"), Value: aws.String("prod")},
+		}},
+		{ImageId: aws.String("ami-1234567890abcdef1"), Tags: []*ec2.Tag{
+			{Key: aws.String("BackupType"), Value: aws.String("auto")},
+			{Key: aws.String("Name"), Value: aws.String("db")},
+			{Key: aws.String("Service"), Value: aws.String("daily")},
+			{Key: aws.String("Env"), Value: aws.String("prod")},
+		}},
+		{ImageId: aws.String("ami-1234567890abcdef2"), Tags: []*ec2.Tag{
+			{Key: aws.String("BackupType"), Value: aws.String("auto")},
+			{Key: aws.String("Name"), Value: aws.String("web")},
+			{Key: aws.String("Service"), Value: aws.String("daily")},
+			{Key: aws.String("Env"), Value: aws.String("stg")},
+		}},
+		{ImageId: aws.String("ami-1234567890abcdef3"), Tags: []*ec2.Tag{
+			{Key: aws.String("BackupType"), Value: aws.String("auto")},
+			{Key: aws.String("Service"), Value: aws.String("daily")},
+		}},
+	}, nil)
+
+	base := &Backup{
+		Generation: 3,
+		GroupBy:    []string{"Service", "Env"},
+		Client:     mockAWSClient,
+	}
+
+	got, err := BackupGroups(context.TODO(), base)
+	if err != nil {
+		t.Fatal("BackupGroups failed: ", err)
+	}
+
+	var labels []string
+	for _, b := range got {
+		labels = append(labels, b.GroupLabel())
+		if b.Generation != 3 {
+			t.Fatalf("got generation %d, want 3", b.Generation)
+		}
+	}
+	want := []string{"Service=daily,Env=prod", "Service=daily,Env=stg"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Fatalf("got %s, want %s", labels, want)
+	}
+}
+
+func TestBackupGroups_selected(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), []*ec2.Tag{{Key: aws.String("BackupType"), Value: aws.String("auto")}}).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), Tags: []*ec2.Tag{
+			{Key: aws.String("BackupType"), Value: aws.String("auto")},
+			{Key: aws.String("Service"), Value: aws.String("daily")},
+			{Key: aws.String("Env"), Value: aws.String("prod")},
+		}},
+		{ImageId: aws.String("ami-1234567890abcdef1"), Tags: []*ec2.Tag{
+			{Key: aws.String("BackupType"), Value: aws.String("auto")},
+			{Key: aws.String("Service"), Value: aws.String("weekly")},
+			{Key: aws.String("Env"), Value: aws.String("prod")},
+		}},
+		{ImageId: aws.String("ami-1234567890abcdef2"), Tags: []*ec2.Tag{
+			{Key: aws.String("BackupType"), Value: aws.String("auto")},
+			{Key: aws.String("Service"), Value: aws.String("daily")},
+			{Key: aws.String("Env"), Value: aws.String("stg")},
+		}},
+	}, nil).Times(3)
+
+	var cases = []struct {
+		service    string
+		customTags []Tag
+		want       []string
+	}{
+		{
+			service: "daily",
+			want:    []string{"Service=daily,Env=prod", "Service=daily,Env=stg"},
+		},
+		{
+			customTags: []Tag{{Key: "Env", Value: "prod"}},
+			want:       []string{"Service=daily,Env=prod", "Service=weekly,Env=prod"},
+		},
+		{
+			service:    "weekly",
+			customTags: []Tag{{Key: "Env", Value: "stg"}},
+		},
+	}
+
+	for _, c := range cases {
+		base := &Backup{
+			Service:    c.service,
+			CustomTags: c.customTags,
+			GroupBy:    []string{"Service", "Env"},
+			Client:     mockAWSClient,
+		}
+
+		got, err := BackupGroups(context.TODO(), base)
+		if err != nil {
+			t.Fatal("BackupGroups failed: ", err)
+		}
+
+		var labels []string
+		for _, b := range got {
+			labels = append(labels, b.GroupLabel())
+		}
+		if !reflect.DeepEqual(labels, c.want) {
+			t.Fatalf("got %s, want %s", labels, c.want)
+		}
+	}
+}
+
+func TestInstanceBackupGroup(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+	}).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), Tags: []*ec2.Tag{
+			{Key: aws.String("Name"), Value: aws.String("old")},
+			{Key: aws.String("Service"), Value: aws.String("daily")},
+		}},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-03T15:04:05.000Z"), Tags: []*ec2.Tag{
+			{Key: aws.String("Name"), Value: aws.String("web")},
+			{Key: aws.String("Service"), Value: aws.String("daily")},
+		}},
+		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-04T15:04:05.000Z"), Tags: []*ec2.Tag{
+			{Key: aws.String("Name"), Value: aws.String("web")},
+			{Key: aws.String("Service"), Value: aws.String("weekly")},
+		}},
+	}, nil)
+
+	base := &Backup{
+		InstanceID: "i-1234567890abcdef0",
+		Service:    "daily",
+		Client:     mockAWSClient,
+	}
+
+	got, ok, err := InstanceBackupGroup(context.TODO(), base)
+	if err != nil {
+		t.Fatal("InstanceBackupGroup failed: ", err)
+	}
+	if !ok {
+		t.Fatal("group should be found")
+	}
+
+	// the latest backup of the instance is selected by Service tag
+	if want := "Name=web,Service=daily"; got.GroupLabel() != want {
+		t.Fatalf("got %s, want %s", got.GroupLabel(), want)
+	}
+}
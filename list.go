@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Output formats of listed backups.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputCSV   = "csv"
+)
+
+// ListFilter selects backups to list, empty values match any backups.
+type ListFilter struct {
+	Name       string
+	Service    string
+	InstanceID string
+	// Since is inclusive and Until is exclusive.
+	Since time.Time
+	Until time.Time
+}
+
+// BackupImage is a machine image of backup to list.
+type BackupImage struct {
+	Group        string            `json:"group"`
+	ImageID      string            `json:"image_id"`
+	Name         string            `json:"name"`
+	State        string            `json:"state"`
+	CreationDate string            `json:"creation_date"`
+	SnapshotIDs  []string          `json:"snapshot_ids"`
+	Size         int64             `json:"size"`
+	Tags         map[string]string `json:"tags"`
+}
+
+// ListBackups returns machine images of backups which match the filter, sorted by group and creation date.
+// The group is the label of generation management group of base, and empty when the machine image is not grouped.
+func ListBackups(ctx context.Context, base *Backup, filter ListFilter) ([]BackupImage, error) {
+	tags := []*ec2.Tag{base.Schema.Tag(TagBackupType, base.Schema.BackupType())}
+	if filter.Name != "" {
+		tags = append(tags, base.Schema.Tag(TagName, filter.Name))
+	}
+	if filter.Service != "" {
+		tags = append(tags, base.Schema.Tag(TagService, filter.Service))
+	}
+	if filter.InstanceID != "" {
+		tags = append(tags, base.Schema.Tag(TagInstanceID, filter.InstanceID))
+	}
+
+	images, err := base.Client.GetImages(ctx, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []BackupImage
+	for _, image := range images {
+		created := convertDate(aws.StringValue(image.CreationDate))
+		if !filter.Since.IsZero() && created.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !created.Before(filter.Until) {
+			continue
+		}
+		backups = append(backups, newBackupImage(base, image))
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		if backups[i].Group != backups[j].Group {
+			return backups[i].Group < backups[j].Group
+		}
+		return convertDate(backups[i].CreationDate).Before(convertDate(backups[j].CreationDate))
+	})
+
+	return backups, nil
+}
+
+func newBackupImage(base *Backup, image *ec2.Image) BackupImage {
+	backup := BackupImage{
+		ImageID:      aws.StringValue(image.ImageId),
+		Name:         aws.StringValue(image.Name),
+		State:        aws.StringValue(image.State),
+		CreationDate: aws.StringValue(image.CreationDate),
+		SnapshotIDs:  imageSnapshots(image),
+		Tags:         make(map[string]string),
+	}
+	if b, ok := imageGroup(base, image); ok {
+		backup.Group = b.GroupLabel()
+	}
+	for _, m := range image.BlockDeviceMappings {
+		if m.Ebs == nil || m.Ebs.SnapshotId == nil || m.NoDevice != nil {
+			continue
+		}
+		backup.Size += aws.Int64Value(m.Ebs.VolumeSize)
+	}
+	for _, t := range image.Tags {
+		backup.Tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return backup
+}
+
+// row returns values of columns of table and CSV output.
+func (b BackupImage) row() []string {
+	var keys []string
+	for k := range b.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var tags []string
+	for _, k := range keys {
+		tags = append(tags, fmt.Sprintf("%s:%s", k, b.Tags[k]))
+	}
+
+	return []string{
+		b.Group,
+		b.ImageID,
+		b.Name,
+		b.State,
+		b.CreationDate,
+		strings.Join(b.SnapshotIDs, ","),
+		strconv.FormatInt(b.Size, 10),
+		strings.Join(tags, ","),
+	}
+}
+
+// backupImageColumns is the header of table and CSV output, the size is in GiB.
+var backupImageColumns = []string{"GROUP", "IMAGE ID", "NAME", "STATE", "CREATION DATE", "SNAPSHOT IDS", "SIZE(GiB)", "TAGS"}
+
+// WriteBackupImages writes backups in the output format.
+func WriteBackupImages(w io.Writer, format string, backups []BackupImage) error {
+	switch format {
+	case OutputJSON:
+		if backups == nil {
+			backups = []BackupImage{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(backups)
+	case OutputCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(backupImageColumns); err != nil {
+			return err
+		}
+		for _, b := range backups {
+			if err := cw.Write(b.row()); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case OutputTable:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(backupImageColumns, "\t"))
+		for _, b := range backups {
+			row := b.row()
+			if row[0] == "" {
+				row[0] = "-"
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format: %s", format)
+}
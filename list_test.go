@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/heartbeatsjp/go-create-image-backup/mock"
+)
+
+func TestListBackups(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Service"), Value: aws.String("daily")},
+	}).Return([]*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), Name: aws.String("web-200601021504"), State: aws.String("available"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"),
+			Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}, {Key: aws.String("Service"), Value: aws.String("daily")}}},
+		{ImageId: aws.String("ami-1234567890abcdef1"), Name: aws.String("web-200601031504"), State: aws.String("available"), CreationDate: aws.String("2006-01-03T15:04:05.000Z"),
+			Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}, {Key: aws.String("Service"), Value: aws.String("daily")}},
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0"), VolumeSize: aws.Int64(8)}},
+				{DeviceName: aws.String("/dev/sdf"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef1"), VolumeSize: aws.Int64(100)}},
+				{DeviceName: aws.String("/dev/sdb"), VirtualName: aws.String("ephemeral0")},
+			}},
+		{ImageId: aws.String("ami-1234567890abcdef2"), Name: aws.String("db-200601021504"), State: aws.String("pending"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"),
+			Tags: []*ec2.Tag{{Key: aws.String("Service"), Value: aws.String("daily")}}},
+		{ImageId: aws.String("ami-1234567890abcdef3"), Name: aws.String("web-200601041504"), State: aws.String("available"), CreationDate: aws.String("2006-01-04T15:04:05.000Z"),
+			Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}, {Key: aws.String("Service"), Value: aws.String("daily")}}},
+	}, nil)
+
+	base := &Backup{Client: mockAWSClient}
+	filter := ListFilter{
+		Service: "daily",
+		Since:   time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC),
+		Until:   time.Date(2006, 1, 4, 0, 0, 0, 0, time.UTC),
+	}
+
+	got, err := ListBackups(context.TODO(), base, filter)
+	if err != nil {
+		t.Fatal("ListBackups failed: ", err)
+	}
+
+	want := []BackupImage{
+		{Group: "", ImageID: "ami-1234567890abcdef2", Name: "db-200601021504", State: "pending", CreationDate: "2006-01-02T16:04:05.000Z",
+			Tags: map[string]string{"Service": "daily"}},
+		{Group: "Name=web,Service=daily", ImageID: "ami-1234567890abcdef0", Name: "web-200601021504", State: "available", CreationDate: "2006-01-02T15:04:05.000Z",
+			Tags: map[string]string{"Name": "web", "Service": "daily"}},
+		{Group: "Name=web,Service=daily", ImageID: "ami-1234567890abcdef1", Name: "web-200601031504", State: "available", CreationDate: "2006-01-03T15:04:05.000Z",
+			SnapshotIDs: []string{"snap-1234567890abcdef0", "snap-1234567890abcdef1"}, Size: 108, Tags: map[string]string{"Name": "web", "Service": "daily"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteBackupImages(t *testing.T) {
+	backups := []BackupImage{
+		{Group: "Name=web,Service=", ImageID: "ami-1234567890abcdef0", Name: "web-200601021504", State: "available", CreationDate: "2006-01-02T15:04:05.000Z",
+			SnapshotIDs: []string{"snap-1234567890abcdef0", "snap-1234567890abcdef1"}, Size: 108, Tags: map[string]string{"Service": "", "Name": "web"}},
+	}
+
+	var cases = []struct {
+		format string
+		want   string
+	}{
+		{
+			format: OutputTable,
+			want: "GROUP              IMAGE ID               NAME              STATE      CREATION DATE             SNAPSHOT IDS                                   SIZE(GiB)  TAGS\n" +
+				"Name=web,Service=  ami-1234567890abcdef0  web-200601021504  available  2006-01-02T15:04:05.000Z  snap-1234567890abcdef0,snap-1234567890abcdef1  108        Name:web,Service:\n",
+		},
+		{
+			format: OutputCSV,
+			want: "GROUP,IMAGE ID,NAME,STATE,CREATION DATE,SNAPSHOT IDS,SIZE(GiB),TAGS\n" +
+				"\"Name=web,Service=\",ami-1234567890abcdef0,web-200601021504,available,2006-01-02T15:04:05.000Z,\"snap-1234567890abcdef0,snap-1234567890abcdef1\",108,\"Name:web,Service:\"\n",
+		},
+		{
+			format: OutputJSON,
+			want: `[
+  {
+    "group": "Name=web,Service=",
+    "image_id": "ami-1234567890abcdef0",
+    "name": "web-200601021504",
+    "state": "available",
+    "creation_date": "2006-01-02T15:04:05.000Z",
+    "snapshot_ids": [
+      "snap-1234567890abcdef0",
+      "snap-1234567890abcdef1"
+    ],
+    "size": 108,
+    "tags": {
+      "Name": "web",
+      "Service": ""
+    }
+  }
+]
+`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := WriteBackupImages(buf, c.format, backups); err != nil {
+				t.Fatal("WriteBackupImages failed: ", err)
+			}
+			if got := buf.String(); got != c.want {
+				t.Fatalf("got\n%s\nwant\n%s", got, c.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,6 @@ package main
 import "os"
 
 func main() {
-	cli := &CLI{outStream: os.Stdout, errStream: os.Stderr, mail: MailClient{}}
+	cli := &CLI{outStream: os.Stdout, errStream: os.Stderr, inStream: os.Stdin, mail: MailClient{}}
 	os.Exit(cli.Run(os.Args))
 }
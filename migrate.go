@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TagMigration retags existing backups from a tag schema to another tag schema.
+type TagMigration struct {
+	From   TagSchema
+	To     TagSchema
+	Client AWS
+}
+
+// Run retags machine images and snapshots which have BackupType tag of From schema,
+// and returns ids of retagged resources.
+func (m *TagMigration) Run(ctx context.Context) ([]string, error) {
+	var migratedIDs []string
+
+	backupType := []*ec2.Tag{m.From.Tag(TagBackupType, m.From.BackupType())}
+
+	images, err := m.Client.GetImages(ctx, backupType)
+	if err != nil {
+		return migratedIDs, err
+	}
+	for _, image := range images {
+		migrated, err := m.migrate(ctx, *image.ImageId, image.Tags)
+		if err != nil {
+			return migratedIDs, err
+		}
+		if migrated {
+			migratedIDs = append(migratedIDs, *image.ImageId)
+		}
+	}
+
+	// snapshots of machine images and snapshot-only backups
+	snapshots, err := m.Client.GetTaggedSnapshots(ctx, backupType, nil)
+	if err != nil {
+		return migratedIDs, err
+	}
+	for _, snapshot := range snapshots {
+		migrated, err := m.migrate(ctx, *snapshot.SnapshotId, snapshot.Tags)
+		if err != nil {
+			return migratedIDs, err
+		}
+		if migrated {
+			migratedIDs = append(migratedIDs, *snapshot.SnapshotId)
+		}
+	}
+
+	return migratedIDs, nil
+}
+
+// migrate creates tags of To schema for tags of From schema, then deletes tags of From schema which are renamed.
+func (m *TagMigration) migrate(ctx context.Context, resourceID string, tags []*ec2.Tag) (bool, error) {
+	var newTags []*ec2.Tag
+	var oldKeys []string
+	for _, t := range tags {
+		key, ok := m.From.defaultKey(aws.StringValue(t.Key))
+		if !ok {
+			continue
+		}
+
+		value := aws.StringValue(t.Value)
+		if key == TagBackupType {
+			value = m.To.BackupType()
+		}
+
+		newTag := m.To.Tag(key, value)
+		if *newTag.Key == aws.StringValue(t.Key) && value == aws.StringValue(t.Value) {
+			continue
+		}
+		newTags = append(newTags, newTag)
+		if *newTag.Key != aws.StringValue(t.Key) {
+			oldKeys = append(oldKeys, aws.StringValue(t.Key))
+		}
+	}
+
+	if len(newTags) < 1 {
+		return false, nil
+	}
+
+	if err := m.Client.CreateTags(ctx, resourceID, newTags); err != nil {
+		return false, err
+	}
+
+	// keys which are renamed to other keys of To schema must not be deleted
+	var deleteKeys []string
+	for _, k := range oldKeys {
+		if !hasTag(newTags, k) {
+			deleteKeys = append(deleteKeys, k)
+		}
+	}
+	if len(deleteKeys) > 0 {
+		if err := m.Client.DeleteTags(ctx, resourceID, deleteKeys); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/heartbeatsjp/go-create-image-backup/mock"
+)
+
+func TestTagMigrationRun(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	backupType := []*ec2.Tag{{Key: aws.String("BackupType"), Value: aws.String("auto")}}
+	mockAWSClient.EXPECT().GetImages(context.TODO(), backupType).Return([]*ec2.Image{
+		{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("Name"), Value: aws.String("test")},
+				{Key: aws.String("Service"), Value: aws.String("service")},
+				{Key: aws.String("key1"), Value: aws.String("val1")},
+			},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "ami-1234567890abcdef0", []*ec2.Tag{
+		{Key: aws.String("backup:BackupType"), Value: aws.String("go-create-image-backup")},
+		{Key: aws.String("backup:Name"), Value: aws.String("test")},
+		{Key: aws.String("backup:BackupService"), Value: aws.String("service")},
+	}).Return(nil)
+	mockAWSClient.EXPECT().DeleteTags(context.TODO(), "ami-1234567890abcdef0", []string{"BackupType", "Name", "Service"}).Return(nil)
+	mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), backupType, gomock.Nil()).Return([]*ec2.Snapshot{
+		{
+			SnapshotId: aws.String("snap-1234567890abcdef0"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("DeviceName"), Value: aws.String("/dev/xvda")},
+			},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().CreateTags(context.TODO(), "snap-1234567890abcdef0", []*ec2.Tag{
+		{Key: aws.String("backup:BackupType"), Value: aws.String("go-create-image-backup")},
+		{Key: aws.String("backup:DeviceName"), Value: aws.String("/dev/xvda")},
+	}).Return(nil)
+	mockAWSClient.EXPECT().DeleteTags(context.TODO(), "snap-1234567890abcdef0", []string{"BackupType", "DeviceName"}).Return(nil)
+
+	migration := &TagMigration{
+		To: TagSchema{
+			Prefix:          "backup:",
+			Keys:            map[string]string{"Service": "BackupService"},
+			BackupTypeValue: "go-create-image-backup",
+		},
+		Client: mockAWSClient,
+	}
+
+	got, err := migration.Run(context.TODO())
+	if err != nil {
+		t.Fatal("Run failed: ", err)
+	}
+
+	want := []string{"ami-1234567890abcdef0", "snap-1234567890abcdef0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestTagMigrationRun_NotChanged(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	backupType := []*ec2.Tag{{Key: aws.String("BackupType"), Value: aws.String("auto")}}
+	mockAWSClient.EXPECT().GetImages(context.TODO(), backupType).Return([]*ec2.Image{
+		{
+			ImageId: aws.String("ami-1234567890abcdef0"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("BackupType"), Value: aws.String("auto")},
+				{Key: aws.String("Name"), Value: aws.String("test")},
+			},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), backupType, gomock.Nil()).Return([]*ec2.Snapshot{}, nil)
+
+	migration := &TagMigration{Client: mockAWSClient}
+
+	got, err := migration.Run(context.TODO())
+	if err != nil {
+		t.Fatal("Run failed: ", err)
+	}
+
+	if len(got) != 0 {
+		t.Fatalf("got %s, want empty", got)
+	}
+}
@@ -7,6 +7,7 @@ package mock
 import (
 	"context"
 	"reflect"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/mock/gomock"
@@ -74,17 +75,69 @@ func (mr *MockAWSMockRecorder) GetInstanceName(ctx, instanceID interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInstanceName", reflect.TypeOf((*MockAWS)(nil).GetInstanceName), ctx, instanceID)
 }
 
+// GetInstanceTags mocks base method
+func (m *MockAWS) GetInstanceTags(ctx context.Context, instanceID string) ([]*ec2.Tag, error) {
+	ret := m.ctrl.Call(m, "GetInstanceTags", ctx, instanceID)
+	ret0, _ := ret[0].([]*ec2.Tag)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInstanceTags indicates an expected call of GetInstanceTags
+func (mr *MockAWSMockRecorder) GetInstanceTags(ctx, instanceID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInstanceTags", reflect.TypeOf((*MockAWS)(nil).GetInstanceTags), ctx, instanceID)
+}
+
+// GetInstanceIDs mocks base method
+func (m *MockAWS) GetInstanceIDs(ctx context.Context, filters []*ec2.Filter) ([]string, error) {
+	ret := m.ctrl.Call(m, "GetInstanceIDs", ctx, filters)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInstanceIDs indicates an expected call of GetInstanceIDs
+func (mr *MockAWSMockRecorder) GetInstanceIDs(ctx, filters interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInstanceIDs", reflect.TypeOf((*MockAWS)(nil).GetInstanceIDs), ctx, filters)
+}
+
+// GetInstanceVolumes mocks base method
+func (m *MockAWS) GetInstanceVolumes(ctx context.Context, instanceID string) ([]*ec2.Volume, error) {
+	ret := m.ctrl.Call(m, "GetInstanceVolumes", ctx, instanceID)
+	ret0, _ := ret[0].([]*ec2.Volume)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInstanceVolumes indicates an expected call of GetInstanceVolumes
+func (mr *MockAWSMockRecorder) GetInstanceVolumes(ctx, instanceID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInstanceVolumes", reflect.TypeOf((*MockAWS)(nil).GetInstanceVolumes), ctx, instanceID)
+}
+
 // CreateImage mocks base method
-func (m *MockAWS) CreateImage(ctx context.Context, instanceID, name, now string) (string, error) {
-	ret := m.ctrl.Call(m, "CreateImage", ctx, instanceID, name, now)
+func (m *MockAWS) CreateImage(ctx context.Context, instanceID, name, description string, excludeDevices []string) (string, error) {
+	ret := m.ctrl.Call(m, "CreateImage", ctx, instanceID, name, description, excludeDevices)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateImage indicates an expected call of CreateImage
-func (mr *MockAWSMockRecorder) CreateImage(ctx, instanceID, name, now interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateImage", reflect.TypeOf((*MockAWS)(nil).CreateImage), ctx, instanceID, name, now)
+func (mr *MockAWSMockRecorder) CreateImage(ctx, instanceID, name, description, excludeDevices interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateImage", reflect.TypeOf((*MockAWS)(nil).CreateImage), ctx, instanceID, name, description, excludeDevices)
+}
+
+// CopyImage mocks base method
+func (m *MockAWS) CopyImage(ctx context.Context, sourceRegion, sourceImageID, name, description, kmsKeyID string) (string, error) {
+	ret := m.ctrl.Call(m, "CopyImage", ctx, sourceRegion, sourceImageID, name, description, kmsKeyID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CopyImage indicates an expected call of CopyImage
+func (mr *MockAWSMockRecorder) CopyImage(ctx, sourceRegion, sourceImageID, name, description, kmsKeyID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyImage", reflect.TypeOf((*MockAWS)(nil).CopyImage), ctx, sourceRegion, sourceImageID, name, description, kmsKeyID)
 }
 
 // CreateTags mocks base method
@@ -99,17 +152,29 @@ func (mr *MockAWSMockRecorder) CreateTags(ctx, resourceID, tags interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTags", reflect.TypeOf((*MockAWS)(nil).CreateTags), ctx, resourceID, tags)
 }
 
+// DeleteTags mocks base method
+func (m *MockAWS) DeleteTags(ctx context.Context, resourceID string, keys []string) error {
+	ret := m.ctrl.Call(m, "DeleteTags", ctx, resourceID, keys)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteTags indicates an expected call of DeleteTags
+func (mr *MockAWSMockRecorder) DeleteTags(ctx, resourceID, keys interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTags", reflect.TypeOf((*MockAWS)(nil).DeleteTags), ctx, resourceID, keys)
+}
+
 // GetImages mocks base method
-func (m *MockAWS) GetImages(ctx context.Context, name, service string) ([]*ec2.Image, error) {
-	ret := m.ctrl.Call(m, "GetImages", ctx, name, service)
+func (m *MockAWS) GetImages(ctx context.Context, tags []*ec2.Tag) ([]*ec2.Image, error) {
+	ret := m.ctrl.Call(m, "GetImages", ctx, tags)
 	ret0, _ := ret[0].([]*ec2.Image)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetImages indicates an expected call of GetImages
-func (mr *MockAWSMockRecorder) GetImages(ctx, name, service interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImages", reflect.TypeOf((*MockAWS)(nil).GetImages), ctx, name, service)
+func (mr *MockAWSMockRecorder) GetImages(ctx, tags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImages", reflect.TypeOf((*MockAWS)(nil).GetImages), ctx, tags)
 }
 
 // GetImage mocks base method
@@ -125,6 +190,19 @@ func (mr *MockAWSMockRecorder) GetImage(ctx, imageID interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImage", reflect.TypeOf((*MockAWS)(nil).GetImage), ctx, imageID)
 }
 
+// ImageNameExists mocks base method
+func (m *MockAWS) ImageNameExists(ctx context.Context, name string) (bool, error) {
+	ret := m.ctrl.Call(m, "ImageNameExists", ctx, name)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageNameExists indicates an expected call of ImageNameExists
+func (mr *MockAWSMockRecorder) ImageNameExists(ctx, name interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageNameExists", reflect.TypeOf((*MockAWS)(nil).ImageNameExists), ctx, name)
+}
+
 // GetSnapshots mocks base method
 func (m *MockAWS) GetSnapshots(ctx context.Context, imageID string) ([]string, error) {
 	ret := m.ctrl.Call(m, "GetSnapshots", ctx, imageID)
@@ -138,6 +216,92 @@ func (mr *MockAWSMockRecorder) GetSnapshots(ctx, imageID interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSnapshots", reflect.TypeOf((*MockAWS)(nil).GetSnapshots), ctx, imageID)
 }
 
+// CreateSnapshots mocks base method
+func (m *MockAWS) CreateSnapshots(ctx context.Context, instanceID string, excludeVolumeIDs []string, tags []*ec2.Tag) ([]string, error) {
+	ret := m.ctrl.Call(m, "CreateSnapshots", ctx, instanceID, excludeVolumeIDs, tags)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateSnapshots indicates an expected call of CreateSnapshots
+func (mr *MockAWSMockRecorder) CreateSnapshots(ctx, instanceID, excludeVolumeIDs, tags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSnapshots", reflect.TypeOf((*MockAWS)(nil).CreateSnapshots), ctx, instanceID, excludeVolumeIDs, tags)
+}
+
+// GetTaggedSnapshots mocks base method
+func (m *MockAWS) GetTaggedSnapshots(ctx context.Context, tags []*ec2.Tag, tagKeys []string) ([]*ec2.Snapshot, error) {
+	ret := m.ctrl.Call(m, "GetTaggedSnapshots", ctx, tags, tagKeys)
+	ret0, _ := ret[0].([]*ec2.Snapshot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTaggedSnapshots indicates an expected call of GetTaggedSnapshots
+func (mr *MockAWSMockRecorder) GetTaggedSnapshots(ctx, tags, tagKeys interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTaggedSnapshots", reflect.TypeOf((*MockAWS)(nil).GetTaggedSnapshots), ctx, tags, tagKeys)
+}
+
+// DeleteSnapshots mocks base method
+func (m *MockAWS) DeleteSnapshots(ctx context.Context, snapshotIDs []string) error {
+	ret := m.ctrl.Call(m, "DeleteSnapshots", ctx, snapshotIDs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteSnapshots indicates an expected call of DeleteSnapshots
+func (mr *MockAWSMockRecorder) DeleteSnapshots(ctx, snapshotIDs interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSnapshots", reflect.TypeOf((*MockAWS)(nil).DeleteSnapshots), ctx, snapshotIDs)
+}
+
+// DeprecateImage mocks base method
+func (m *MockAWS) DeprecateImage(ctx context.Context, imageID string, deprecateAt time.Time) error {
+	ret := m.ctrl.Call(m, "DeprecateImage", ctx, imageID, deprecateAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeprecateImage indicates an expected call of DeprecateImage
+func (mr *MockAWSMockRecorder) DeprecateImage(ctx, imageID, deprecateAt interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeprecateImage", reflect.TypeOf((*MockAWS)(nil).DeprecateImage), ctx, imageID, deprecateAt)
+}
+
+// ShareImage mocks base method
+func (m *MockAWS) ShareImage(ctx context.Context, imageID string, principals []string) error {
+	ret := m.ctrl.Call(m, "ShareImage", ctx, imageID, principals)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ShareImage indicates an expected call of ShareImage
+func (mr *MockAWSMockRecorder) ShareImage(ctx, imageID, principals interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ShareImage", reflect.TypeOf((*MockAWS)(nil).ShareImage), ctx, imageID, principals)
+}
+
+// UnshareImage mocks base method
+func (m *MockAWS) UnshareImage(ctx context.Context, image *ec2.Image) error {
+	ret := m.ctrl.Call(m, "UnshareImage", ctx, image)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnshareImage indicates an expected call of UnshareImage
+func (mr *MockAWSMockRecorder) UnshareImage(ctx, image interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnshareImage", reflect.TypeOf((*MockAWS)(nil).UnshareImage), ctx, image)
+}
+
+// ArchiveImage mocks base method
+func (m *MockAWS) ArchiveImage(ctx context.Context, image *ec2.Image, tags []*ec2.Tag) error {
+	ret := m.ctrl.Call(m, "ArchiveImage", ctx, image, tags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ArchiveImage indicates an expected call of ArchiveImage
+func (mr *MockAWSMockRecorder) ArchiveImage(ctx, image, tags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ArchiveImage", reflect.TypeOf((*MockAWS)(nil).ArchiveImage), ctx, image, tags)
+}
+
 // DeregisterImages mocks base method
 func (m *MockAWS) DeregisterImages(ctx context.Context, images []*ec2.Image) error {
 	ret := m.ctrl.Call(m, "DeregisterImages", ctx, images)
@@ -149,3 +313,140 @@ func (m *MockAWS) DeregisterImages(ctx context.Context, images []*ec2.Image) err
 func (mr *MockAWSMockRecorder) DeregisterImages(ctx, images interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeregisterImages", reflect.TypeOf((*MockAWS)(nil).DeregisterImages), ctx, images)
 }
+
+// DryRunCreateImage mocks base method
+func (m *MockAWS) DryRunCreateImage(ctx context.Context, instanceID, name, description string, excludeDevices []string) error {
+	ret := m.ctrl.Call(m, "DryRunCreateImage", ctx, instanceID, name, description, excludeDevices)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DryRunCreateImage indicates an expected call of DryRunCreateImage
+func (mr *MockAWSMockRecorder) DryRunCreateImage(ctx, instanceID, name, description, excludeDevices interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DryRunCreateImage", reflect.TypeOf((*MockAWS)(nil).DryRunCreateImage), ctx, instanceID, name, description, excludeDevices)
+}
+
+// DryRunDeregisterImages mocks base method
+func (m *MockAWS) DryRunDeregisterImages(ctx context.Context, images []*ec2.Image) error {
+	ret := m.ctrl.Call(m, "DryRunDeregisterImages", ctx, images)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DryRunDeregisterImages indicates an expected call of DryRunDeregisterImages
+func (mr *MockAWSMockRecorder) DryRunDeregisterImages(ctx, images interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DryRunDeregisterImages", reflect.TypeOf((*MockAWS)(nil).DryRunDeregisterImages), ctx, images)
+}
+
+// FindImages mocks base method
+func (m *MockAWS) FindImages(ctx context.Context, imageIDs []string) ([]*ec2.Image, error) {
+	ret := m.ctrl.Call(m, "FindImages", ctx, imageIDs)
+	ret0, _ := ret[0].([]*ec2.Image)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindImages indicates an expected call of FindImages
+func (mr *MockAWSMockRecorder) FindImages(ctx, imageIDs interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindImages", reflect.TypeOf((*MockAWS)(nil).FindImages), ctx, imageIDs)
+}
+
+// FindSnapshots mocks base method
+func (m *MockAWS) FindSnapshots(ctx context.Context, snapshotIDs []string) ([]*ec2.Snapshot, error) {
+	ret := m.ctrl.Call(m, "FindSnapshots", ctx, snapshotIDs)
+	ret0, _ := ret[0].([]*ec2.Snapshot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindSnapshots indicates an expected call of FindSnapshots
+func (mr *MockAWSMockRecorder) FindSnapshots(ctx, snapshotIDs interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindSnapshots", reflect.TypeOf((*MockAWS)(nil).FindSnapshots), ctx, snapshotIDs)
+}
+
+// GetInstance mocks base method
+func (m *MockAWS) GetInstance(ctx context.Context, instanceID string) (*ec2.Instance, error) {
+	ret := m.ctrl.Call(m, "GetInstance", ctx, instanceID)
+	ret0, _ := ret[0].(*ec2.Instance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInstance indicates an expected call of GetInstance
+func (mr *MockAWSMockRecorder) GetInstance(ctx, instanceID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInstance", reflect.TypeOf((*MockAWS)(nil).GetInstance), ctx, instanceID)
+}
+
+// RunInstance mocks base method
+func (m *MockAWS) RunInstance(ctx context.Context, input *ec2.RunInstancesInput) (string, error) {
+	ret := m.ctrl.Call(m, "RunInstance", ctx, input)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RunInstance indicates an expected call of RunInstance
+func (mr *MockAWSMockRecorder) RunInstance(ctx, input interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunInstance", reflect.TypeOf((*MockAWS)(nil).RunInstance), ctx, input)
+}
+
+// CreateVolume mocks base method
+func (m *MockAWS) CreateVolume(ctx context.Context, snapshotID, availabilityZone, volumeType string, iops, throughput int64, tags []*ec2.Tag) (string, error) {
+	ret := m.ctrl.Call(m, "CreateVolume", ctx, snapshotID, availabilityZone, volumeType, iops, throughput, tags)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateVolume indicates an expected call of CreateVolume
+func (mr *MockAWSMockRecorder) CreateVolume(ctx, snapshotID, availabilityZone, volumeType, iops, throughput, tags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateVolume", reflect.TypeOf((*MockAWS)(nil).CreateVolume), ctx, snapshotID, availabilityZone, volumeType, iops, throughput, tags)
+}
+
+// AttachVolume mocks base method
+func (m *MockAWS) AttachVolume(ctx context.Context, volumeID, instanceID, device string) error {
+	ret := m.ctrl.Call(m, "AttachVolume", ctx, volumeID, instanceID, device)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AttachVolume indicates an expected call of AttachVolume
+func (mr *MockAWSMockRecorder) AttachVolume(ctx, volumeID, instanceID, device interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AttachVolume", reflect.TypeOf((*MockAWS)(nil).AttachVolume), ctx, volumeID, instanceID, device)
+}
+
+// DryRunCreateSnapshots mocks base method
+func (m *MockAWS) DryRunCreateSnapshots(ctx context.Context, instanceID string, excludeVolumeIDs []string, tags []*ec2.Tag) error {
+	ret := m.ctrl.Call(m, "DryRunCreateSnapshots", ctx, instanceID, excludeVolumeIDs, tags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DryRunCreateSnapshots indicates an expected call of DryRunCreateSnapshots
+func (mr *MockAWSMockRecorder) DryRunCreateSnapshots(ctx, instanceID, excludeVolumeIDs, tags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DryRunCreateSnapshots", reflect.TypeOf((*MockAWS)(nil).DryRunCreateSnapshots), ctx, instanceID, excludeVolumeIDs, tags)
+}
+
+// DryRunDeleteSnapshots mocks base method
+func (m *MockAWS) DryRunDeleteSnapshots(ctx context.Context, snapshotIDs []string) error {
+	ret := m.ctrl.Call(m, "DryRunDeleteSnapshots", ctx, snapshotIDs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DryRunDeleteSnapshots indicates an expected call of DryRunDeleteSnapshots
+func (mr *MockAWSMockRecorder) DryRunDeleteSnapshots(ctx, snapshotIDs interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DryRunDeleteSnapshots", reflect.TypeOf((*MockAWS)(nil).DryRunDeleteSnapshots), ctx, snapshotIDs)
+}
+
+// DryRunArchiveImage mocks base method
+func (m *MockAWS) DryRunArchiveImage(ctx context.Context, image *ec2.Image, tags []*ec2.Tag) error {
+	ret := m.ctrl.Call(m, "DryRunArchiveImage", ctx, image, tags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DryRunArchiveImage indicates an expected call of DryRunArchiveImage
+func (mr *MockAWSMockRecorder) DryRunArchiveImage(ctx, image, tags interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DryRunArchiveImage", reflect.TypeOf((*MockAWS)(nil).DryRunArchiveImage), ctx, image, tags)
+}
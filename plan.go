@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// PlanImageID is the placeholder of the id of the machine image which would be created by Create.
+const PlanImageID = "(new image)"
+
+// CreatePlan is the machine image which would be created by Create.
+type CreatePlan struct {
+	Name        string
+	Description string
+	Tags        []*ec2.Tag
+	// Volumes are ids of EBS volumes which would be included in the machine image by device name.
+	Volumes map[string]string
+	// ExcludeDevices are device names of EBS volumes which would be excluded from the machine image.
+	ExcludeDevices []string
+}
+
+// SnapshotSetPlan is the snapshot-only backup which would be created by CreateSnapshotSet.
+type SnapshotSetPlan struct {
+	SetID string
+	Tags  []*ec2.Tag
+	// ExcludeVolumeIDs are ids of EBS volumes which would be excluded from the backup.
+	ExcludeVolumeIDs []string
+}
+
+// ExpiredSnapshotSet is the snapshot-only backup which would be deleted by RotateSnapshotSets.
+type ExpiredSnapshotSet struct {
+	SetID       string
+	SnapshotIDs []string
+}
+
+// RotatePlan is the machine images which would be archived and deregistered by Archive and Rotate after Create.
+type RotatePlan struct {
+	Archived []*ec2.Image
+	Expired  []ExpiredImage
+}
+
+// PlanCreate returns the machine image which would be created by Create without creating it.
+// Permissions to create the machine image are verified by dry run.
+func (b *Backup) PlanCreate(ctx context.Context) (*CreatePlan, error) {
+	imageName, description, err := b.imageName(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	volumes, err := b.Client.GetInstanceVolumes(ctx, b.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	excludeDevices, _ := b.excludeVolumes(volumes)
+
+	if err := b.Client.DryRunCreateImage(ctx, b.InstanceID, imageName, description, excludeDevices); err != nil {
+		return nil, err
+	}
+
+	tag := b.tags()
+	if !b.PropagateTags.isEmpty() {
+		instanceTags, err := b.Client.GetInstanceTags(ctx, b.InstanceID)
+		if err != nil {
+			return nil, err
+		}
+		tag = append(tag, b.PropagateTags.filter(instanceTags, tag)...)
+	}
+
+	plan := &CreatePlan{
+		Name:           imageName,
+		Description:    description,
+		Tags:           tag,
+		Volumes:        make(map[string]string),
+		ExcludeDevices: excludeDevices,
+	}
+	for device, v := range b.deviceVolumes(volumes) {
+		excluded := false
+		for _, d := range excludeDevices {
+			if d == device {
+				excluded = true
+			}
+		}
+		if !excluded {
+			plan.Volumes[device] = aws.StringValue(v.VolumeId)
+		}
+	}
+
+	return plan, nil
+}
+
+// PlanRotate returns the machine images which would be archived and deregistered by Archive and Rotate
+// after a new machine image is created when create is true, without archiving and deregistering these.
+// Permissions to archive and tag the machine images, and to deregister the machine images and delete their snapshots
+// are verified by dry run.
+func (b *Backup) PlanRotate(ctx context.Context, create bool) (*RotatePlan, error) {
+	images, encryptedImages, err := b.images(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	recentlyImageID := ""
+	if create {
+		const layout = "2006-01-02T15:04:05.000Z"
+		images = append(images, &ec2.Image{
+			ImageId:      aws.String(PlanImageID),
+			CreationDate: aws.String(time.Now().UTC().Format(layout)),
+			State:        aws.String("pending"),
+		})
+		recentlyImageID = PlanImageID
+	}
+
+	plan := &RotatePlan{}
+
+	// archived images are counted as archive generation by Rotate after Archive
+	if b.ArchiveGeneration > 0 {
+		archiveImages := make(map[string]*ec2.Image)
+		for _, image := range b.archiveCandidates(images) {
+			archived := *image
+			archived.Tags = append(append([]*ec2.Tag{}, image.Tags...), b.Schema.Tag(TagBackupTier, "archive"))
+			archiveImages[*image.ImageId] = &archived
+			plan.Archived = append(plan.Archived, image)
+			plan.Archived = append(plan.Archived, encryptedImages[*image.ImageId]...)
+		}
+		for i, image := range images {
+			if archived, ok := archiveImages[*image.ImageId]; ok {
+				images[i] = archived
+			}
+		}
+	}
+
+	for _, image := range plan.Archived {
+		if err := b.Client.DryRunArchiveImage(ctx, image, []*ec2.Tag{b.Schema.Tag(TagBackupTier, "archive")}); err != nil {
+			return nil, err
+		}
+	}
+
+	plan.Expired = b.expiredImages(images, encryptedImages, recentlyImageID)
+
+	var expiredImages []*ec2.Image
+	for _, e := range plan.Expired {
+		expiredImages = append(expiredImages, e.Image)
+	}
+	if len(expiredImages) > 0 {
+		if err := b.Client.DryRunDeregisterImages(ctx, expiredImages); err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+// PlanCreateSnapshotSet returns the snapshot-only backup which would be created by CreateSnapshotSet without creating it.
+// Permissions to create the snapshots are verified by dry run.
+func (b *Backup) PlanCreateSnapshotSet(ctx context.Context) (*SnapshotSetPlan, error) {
+	setID := b.snapshotSetID(time.Now())
+
+	var excludeVolumeIDs []string
+	if !b.Exclude.isEmpty() {
+		volumes, err := b.Client.GetInstanceVolumes(ctx, b.InstanceID)
+		if err != nil {
+			return nil, err
+		}
+		_, excludeVolumeIDs = b.excludeVolumes(volumes)
+	}
+
+	tag := append(b.tags(), b.Schema.Tag(TagBackupSetID, setID))
+
+	if err := b.Client.DryRunCreateSnapshots(ctx, b.InstanceID, excludeVolumeIDs, tag); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotSetPlan{
+		SetID:            setID,
+		Tags:             tag,
+		ExcludeVolumeIDs: excludeVolumeIDs,
+	}, nil
+}
+
+// PlanRotateSnapshotSets returns old snapshot-only backups which would be deleted by RotateSnapshotSets
+// after the recently backup set is created unless its id is empty, without deleting these.
+// Permissions to delete the snapshots are verified by dry run.
+func (b *Backup) PlanRotateSnapshotSets(ctx context.Context, recentlySetID string) ([]ExpiredSnapshotSet, error) {
+	setIDs, setSnapshots, err := b.expiredSnapshotSets(ctx, recentlySetID)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []ExpiredSnapshotSet
+	var snapshotIDs []string
+	for _, setID := range setIDs {
+		expired = append(expired, ExpiredSnapshotSet{SetID: setID, SnapshotIDs: setSnapshots[setID]})
+		snapshotIDs = append(snapshotIDs, setSnapshots[setID]...)
+	}
+	if len(snapshotIDs) > 0 {
+		if err := b.Client.DryRunDeleteSnapshots(ctx, snapshotIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	return expired, nil
+}
+
+// devices returns device names of the volumes in order.
+func (p *CreatePlan) devices() []string {
+	var devices []string
+	for d := range p.Volumes {
+		devices = append(devices, d)
+	}
+	sort.Strings(devices)
+	return devices
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/heartbeatsjp/go-create-image-backup/mock"
+)
+
+func TestPlanCreate(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().ImageNameExists(context.TODO(), imageNameMatcher("test")).Return(false, nil)
+	mockAWSClient.EXPECT().GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Volume{
+		{
+			VolumeId:    aws.String("vol-1234567890abcdef0"),
+			Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/xvda")}},
+		},
+		{
+			VolumeId:    aws.String("vol-1234567890abcdef1"),
+			Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/sdf")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().DryRunCreateImage(
+		context.TODO(),
+		"i-1234567890abcdef0",
+		imageNameMatcher("test"),
+		"create by go-create-image-backup",
+		[]string{"/dev/sdf"}).Return(nil)
+
+	backup := &Backup{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "test",
+		Service:    "service",
+		Exclude:    ExcludeVolumes{Devices: []string{"/dev/sdf"}},
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.PlanCreate(context.TODO())
+	if err != nil {
+		t.Fatal("PlanCreate failed: ", err)
+	}
+
+	wantTags := []*ec2.Tag{
+		{Key: aws.String("BackupType"), Value: aws.String("auto")},
+		{Key: aws.String("Name"), Value: aws.String("test")},
+		{Key: aws.String("Service"), Value: aws.String("service")},
+		{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+	}
+	if !reflect.DeepEqual(got.Tags, wantTags) {
+		t.Fatalf("got %v, want %v", got.Tags, wantTags)
+	}
+	wantVolumes := map[string]string{"/dev/xvda": "vol-1234567890abcdef0"}
+	if !reflect.DeepEqual(got.Volumes, wantVolumes) {
+		t.Fatalf("got %v, want %v", got.Volumes, wantVolumes)
+	}
+	if want := []string{"/dev/sdf"}; !reflect.DeepEqual(got.ExcludeDevices, want) {
+		t.Fatalf("got %v, want %v", got.ExcludeDevices, want)
+	}
+}
+
+func TestPlanRotate(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	images := []*ec2.Image{
+		{
+			ImageId:      aws.String("ami-1234567890abcdef0"),
+			CreationDate: aws.String("2006-01-02T15:04:05.000Z"),
+			State:        aws.String("available"),
+			BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+				{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0")}},
+			},
+		},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2006-01-02T17:04:05.000Z"), State: aws.String("available")},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return(images, nil)
+	mockAWSClient.EXPECT().DryRunDeregisterImages(context.TODO(), images[:2]).Return(nil)
+
+	backup := &Backup{
+		Name:       "test",
+		Service:    "service",
+		Generation: 2,
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.PlanRotate(context.TODO(), true)
+	if err != nil {
+		t.Fatal("PlanRotate failed: ", err)
+	}
+
+	want := &RotatePlan{
+		Expired: []ExpiredImage{
+			{Image: images[0], Reason: "exceeds backup generation 2"},
+			{Image: images[1], Reason: "exceeds backup generation 2"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestPlanRotate_Archive(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	images := []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("2006-01-02T15:04:05.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2006-01-02T16:04:05.000Z"), State: aws.String("available")},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("test", "service")).Return(images, nil)
+	mockAWSClient.EXPECT().DryRunArchiveImage(context.TODO(), images[0], []*ec2.Tag{
+		{Key: aws.String("BackupTier"), Value: aws.String("archive")},
+	}).Return(nil)
+
+	backup := &Backup{
+		Name:              "test",
+		Service:           "service",
+		Generation:        2,
+		ArchiveGeneration: 2,
+		Client:            mockAWSClient,
+	}
+
+	got, err := backup.PlanRotate(context.TODO(), true)
+	if err != nil {
+		t.Fatal("PlanRotate failed: ", err)
+	}
+
+	want := &RotatePlan{Archived: []*ec2.Image{images[0]}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestPlanCreateSnapshotSet(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstanceVolumes(context.TODO(), "i-1234567890abcdef0").Return([]*ec2.Volume{
+		{
+			VolumeId:    aws.String("vol-1234567890abcdef0"),
+			Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/xvda")}},
+		},
+		{
+			VolumeId:    aws.String("vol-1234567890abcdef1"),
+			Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1234567890abcdef0"), Device: aws.String("/dev/sdf")}},
+		},
+	}, nil)
+	mockAWSClient.EXPECT().DryRunCreateSnapshots(
+		context.TODO(),
+		"i-1234567890abcdef0",
+		[]string{"vol-1234567890abcdef1"},
+		gomock.Any()).Return(nil)
+
+	backup := &Backup{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "test",
+		Service:    "service",
+		Exclude:    ExcludeVolumes{Devices: []string{"/dev/sdf"}},
+		Client:     mockAWSClient,
+	}
+
+	got, err := backup.PlanCreateSnapshotSet(context.TODO())
+	if err != nil {
+		t.Fatal("PlanCreateSnapshotSet failed: ", err)
+	}
+
+	if !strings.HasPrefix(got.SetID, "i-1234567890abcdef0-") {
+		t.Fatalf("got %s, want i-1234567890abcdef0-<timestamp>", got.SetID)
+	}
+	if len(got.Tags) != 5 || *got.Tags[4].Key != "BackupSetId" || *got.Tags[4].Value != got.SetID {
+		t.Fatalf("unexpected tags: %s", got.Tags)
+	}
+	if want := []string{"vol-1234567890abcdef1"}; !reflect.DeepEqual(got.ExcludeVolumeIDs, want) {
+		t.Fatalf("got %v, want %v", got.ExcludeVolumeIDs, want)
+	}
+}
+
+func TestPlanRotateSnapshotSets(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	snapshot := func(snapshotID, setID string, startTime time.Time) *ec2.Snapshot {
+		return &ec2.Snapshot{
+			SnapshotId: aws.String(snapshotID),
+			StartTime:  aws.Time(startTime),
+			Tags:       []*ec2.Tag{{Key: aws.String("BackupSetId"), Value: aws.String(setID)}},
+		}
+	}
+	base := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetTaggedSnapshots(context.TODO(), groupTags("test", "service"), []string{"BackupSetId"}).Return([]*ec2.Snapshot{
+		snapshot("snap-1234567890abcdef0", "set0", base),
+		snapshot("snap-1234567890abcdef1", "set0", base.Add(time.Second)),
+		snapshot("snap-1234567890abcdef2", "set1", base.Add(time.Hour)),
+	}, nil)
+	mockAWSClient.EXPECT().DryRunDeleteSnapshots(context.TODO(), []string{"snap-1234567890abcdef0", "snap-1234567890abcdef1"}).Return(nil)
+
+	backup := &Backup{
+		Name:       "test",
+		Service:    "service",
+		Generation: 2,
+		Client:     mockAWSClient,
+	}
+
+	// the new backup set is counted in the generation
+	got, err := backup.PlanRotateSnapshotSets(context.TODO(), "set2")
+	if err != nil {
+		t.Fatal("PlanRotateSnapshotSets failed: ", err)
+	}
+
+	want := []ExpiredSnapshotSet{{SetID: "set0", SnapshotIDs: []string{"snap-1234567890abcdef0", "snap-1234567890abcdef1"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
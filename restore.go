@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// LatestImage selects the newest backup of the group to restore instead of the machine image id.
+const LatestImage = "latest"
+
+// InstanceSettings are settings of instance to launch from a backup, empty values are taken from the source instance.
+type InstanceSettings struct {
+	InstanceType     string
+	SubnetID         string
+	SecurityGroupIDs []string
+	// IAMInstanceProfile is the name or ARN of the instance profile.
+	IAMInstanceProfile string
+	KeyName            string
+}
+
+// Restore launches an instance from a backup.
+type Restore struct {
+	// ImageID is the machine image id of the backup, or LatestImage.
+	ImageID string
+	// Name and Service are values of tags of the generation management group to find the latest backup.
+	Name    string
+	Service string
+	// Before selects the latest backup created before the time, zero means the latest backup.
+	Before   time.Time
+	Settings InstanceSettings
+	Schema   TagSchema
+	Client   AWS
+}
+
+// Image returns the machine image of the backup to restore.
+// The latest backup is the newest available machine image of the group which is not archived.
+func (r *Restore) Image(ctx context.Context) (*ec2.Image, error) {
+	if r.ImageID != LatestImage {
+		image, err := r.Client.GetImage(ctx, r.ImageID)
+		if err != nil {
+			return nil, err
+		}
+		if tagValue(image.Tags, r.Schema.Key(TagBackupType)) != r.Schema.BackupType() {
+			return nil, fmt.Errorf("%s is not a backup", r.ImageID)
+		}
+		return image, nil
+	}
+
+	images, err := r.Client.GetImages(ctx, []*ec2.Tag{
+		r.Schema.Tag(TagBackupType, r.Schema.BackupType()),
+		r.Schema.Tag(TagName, r.Name),
+		r.Schema.Tag(TagService, r.Service),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *ec2.Image
+	for _, image := range images {
+		if aws.StringValue(image.State) != "available" || tagValue(image.Tags, r.Schema.Key(TagBackupTier)) == "archive" {
+			continue
+		}
+		created := convertDate(aws.StringValue(image.CreationDate))
+		if !r.Before.IsZero() && !created.Before(r.Before) {
+			continue
+		}
+		if latest == nil || created.After(convertDate(aws.StringValue(latest.CreationDate))) {
+			latest = image
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no backup is found for Name=%s,Service=%s", r.Name, r.Service)
+	}
+	return latest, nil
+}
+
+// Run launches an instance from the machine image of the backup, and returns the instance id.
+// Settings which are not specified are taken from the source instance of the backup if it still exists,
+// and the instance is tagged with the Name of the backup and the ids of the machine image and the source instance.
+func (r *Restore) Run(ctx context.Context, image *ec2.Image) (string, error) {
+	sourceInstanceID := tagValue(image.Tags, r.Schema.Key(TagInstanceID))
+
+	settings := r.Settings
+	if sourceInstanceID != "" {
+		source, err := r.Client.GetInstance(ctx, sourceInstanceID)
+		if err != nil {
+			return "", err
+		}
+		if source != nil {
+			settings = settings.merge(source)
+		}
+	}
+
+	if settings.InstanceType == "" {
+		return "", fmt.Errorf("instance type of the source instance of %s is unknown", *image.ImageId)
+	}
+
+	tags := []*ec2.Tag{r.Schema.Tag(TagRestoredFromImageID, *image.ImageId)}
+	if sourceInstanceID != "" {
+		tags = append(tags, r.Schema.Tag(TagRestoredFromInstanceID, sourceInstanceID))
+	}
+	if name := tagValue(image.Tags, r.Schema.Key(TagName)); name != "" {
+		tags = append(tags, &ec2.Tag{Key: aws.String("Name"), Value: aws.String(name)})
+	}
+
+	input := &ec2.RunInstancesInput{
+		ImageId:      image.ImageId,
+		InstanceType: aws.String(settings.InstanceType),
+		TagSpecifications: []*ec2.TagSpecification{
+			{ResourceType: aws.String(ec2.ResourceTypeInstance), Tags: tags},
+			{ResourceType: aws.String(ec2.ResourceTypeVolume), Tags: tags},
+		},
+	}
+	if settings.SubnetID != "" {
+		input.SubnetId = aws.String(settings.SubnetID)
+	}
+	if len(settings.SecurityGroupIDs) > 0 {
+		input.SecurityGroupIds = aws.StringSlice(settings.SecurityGroupIDs)
+	}
+	if settings.IAMInstanceProfile != "" {
+		if strings.HasPrefix(settings.IAMInstanceProfile, "arn:") {
+			input.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{Arn: aws.String(settings.IAMInstanceProfile)}
+		} else {
+			input.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{Name: aws.String(settings.IAMInstanceProfile)}
+		}
+	}
+	if settings.KeyName != "" {
+		input.KeyName = aws.String(settings.KeyName)
+	}
+
+	return r.Client.RunInstance(ctx, input)
+}
+
+// merge returns the settings whose empty values are taken from the instance.
+func (s InstanceSettings) merge(instance *ec2.Instance) InstanceSettings {
+	if s.InstanceType == "" {
+		s.InstanceType = aws.StringValue(instance.InstanceType)
+	}
+	if s.SubnetID == "" {
+		s.SubnetID = aws.StringValue(instance.SubnetId)
+	}
+	if len(s.SecurityGroupIDs) < 1 {
+		for _, g := range instance.SecurityGroups {
+			s.SecurityGroupIDs = append(s.SecurityGroupIDs, aws.StringValue(g.GroupId))
+		}
+	}
+	if s.IAMInstanceProfile == "" && instance.IamInstanceProfile != nil {
+		s.IAMInstanceProfile = aws.StringValue(instance.IamInstanceProfile.Arn)
+	}
+	if s.KeyName == "" {
+		s.KeyName = aws.StringValue(instance.KeyName)
+	}
+	return s
+}
+
+// VolumeRestore creates an EBS volume from a snapshot of a backup, and attaches it to an instance.
+type VolumeRestore struct {
+	// Device is the device name of the EBS volume in the machine image of the backup.
+	Device string
+	// AvailabilityZone is the availability zone of the EBS volume, empty means the availability zone of the instance.
+	AvailabilityZone string
+	// InstanceID is the instance to attach the EBS volume to, empty means the EBS volume is not attached.
+	InstanceID string
+	// AttachDevice is the device name to attach the EBS volume at, empty means Device.
+	AttachDevice string
+	Schema       TagSchema
+	Client       AWS
+}
+
+// Run creates an EBS volume from the snapshot of the device in the machine image, and returns the volume id.
+// The volume type, IOPS and throughput are taken from the block device mapping of the machine image,
+// and the volume is tagged with the Name of the backup and the ids of the machine image and the snapshot.
+func (r *VolumeRestore) Run(ctx context.Context, image *ec2.Image) (string, error) {
+	var ebs *ec2.EbsBlockDevice
+	for _, m := range image.BlockDeviceMappings {
+		if aws.StringValue(m.DeviceName) == r.Device && m.Ebs != nil && m.Ebs.SnapshotId != nil && m.NoDevice == nil {
+			ebs = m.Ebs
+			break
+		}
+	}
+	if ebs == nil {
+		return "", fmt.Errorf("snapshot of %s is not found in %s", r.Device, *image.ImageId)
+	}
+
+	availabilityZone := r.AvailabilityZone
+	if r.InstanceID != "" {
+		instance, err := r.Client.GetInstance(ctx, r.InstanceID)
+		if err != nil {
+			return "", err
+		}
+		if instance == nil {
+			return "", fmt.Errorf("instance %s is not found", r.InstanceID)
+		}
+		var instanceZone string
+		if instance.Placement != nil {
+			instanceZone = aws.StringValue(instance.Placement.AvailabilityZone)
+		}
+		if availabilityZone == "" {
+			availabilityZone = instanceZone
+		} else if availabilityZone != instanceZone {
+			return "", fmt.Errorf("instance %s is in %s, not in %s", r.InstanceID, instanceZone, availabilityZone)
+		}
+	}
+
+	tags := []*ec2.Tag{
+		r.Schema.Tag(TagRestoredFromImageID, *image.ImageId),
+		r.Schema.Tag(TagRestoredFromSnapshotID, *ebs.SnapshotId),
+	}
+	if name := tagValue(image.Tags, r.Schema.Key(TagName)); name != "" {
+		tags = append(tags, &ec2.Tag{Key: aws.String("Name"), Value: aws.String(name)})
+	}
+
+	volumeID, err := r.Client.CreateVolume(ctx, *ebs.SnapshotId, availabilityZone, aws.StringValue(ebs.VolumeType),
+		aws.Int64Value(ebs.Iops), aws.Int64Value(ebs.Throughput), tags)
+	if err != nil {
+		return "", err
+	}
+
+	if r.InstanceID != "" {
+		if err := r.Client.AttachVolume(ctx, volumeID, r.InstanceID, r.attachDevice()); err != nil {
+			return volumeID, fmt.Errorf("failed to attach %s: %s", volumeID, err.Error())
+		}
+	}
+
+	return volumeID, nil
+}
+
+func (r *VolumeRestore) attachDevice() string {
+	if r.AttachDevice == "" {
+		return r.Device
+	}
+	return r.AttachDevice
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/mock/gomock"
+	"github.com/heartbeatsjp/go-create-image-backup/mock"
+)
+
+func TestRestoreImage_Latest(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	images := []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), State: aws.String("available"), CreationDate: aws.String("2006-01-01T15:04:05.000Z")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), State: aws.String("available"), CreationDate: aws.String("2006-01-02T15:04:05.000Z")},
+		{ImageId: aws.String("ami-1234567890abcdef2"), State: aws.String("available"), CreationDate: aws.String("2006-01-03T15:04:05.000Z"),
+			Tags: []*ec2.Tag{{Key: aws.String("BackupTier"), Value: aws.String("archive")}}},
+		{ImageId: aws.String("ami-1234567890abcdef3"), State: aws.String("failed"), CreationDate: aws.String("2006-01-04T15:04:05.000Z")},
+		{ImageId: aws.String("ami-1234567890abcdef4"), State: aws.String("available"), CreationDate: aws.String("2006-01-05T15:04:05.000Z")},
+	}
+
+	var cases = []struct {
+		name   string
+		before time.Time
+		want   string
+	}{
+		{
+			name: "latest",
+			want: "ami-1234567890abcdef4",
+		},
+		{
+			name:   "latest before",
+			before: time.Date(2006, 1, 5, 0, 0, 0, 0, time.UTC),
+			want:   "ami-1234567890abcdef1",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockAWSClient := mock.NewMockAWS(mockCtrl)
+			mockAWSClient.EXPECT().GetImages(context.TODO(), groupTags("web", "daily")).Return(images, nil)
+
+			restore := &Restore{ImageID: LatestImage, Name: "web", Service: "daily", Before: c.before, Client: mockAWSClient}
+			got, err := restore.Image(context.TODO())
+			if err != nil {
+				t.Fatal("Image failed: ", err)
+			}
+			if *got.ImageId != c.want {
+				t.Fatalf("got %s, want %s", *got.ImageId, c.want)
+			}
+		})
+	}
+}
+
+func TestRestoreImage_NotBackup(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetImage(context.TODO(), "ami-1234567890abcdef0").Return(&ec2.Image{ImageId: aws.String("ami-1234567890abcdef0")}, nil)
+
+	restore := &Restore{ImageID: "ami-1234567890abcdef0", Client: mockAWSClient}
+	if _, err := restore.Image(context.TODO()); err == nil {
+		t.Fatal("Image should fail for machine image which is not a backup")
+	}
+}
+
+func TestRestoreRun(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	image := &ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		Tags: []*ec2.Tag{
+			{Key: aws.String("BackupType"), Value: aws.String("auto")},
+			{Key: aws.String("Name"), Value: aws.String("web")},
+			{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")},
+		},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstance(context.TODO(), "i-1234567890abcdef0").Return(&ec2.Instance{
+		InstanceId:         aws.String("i-1234567890abcdef0"),
+		InstanceType:       aws.String("t3.micro"),
+		SubnetId:           aws.String("subnet-1234567890abcdef0"),
+		SecurityGroups:     []*ec2.GroupIdentifier{{GroupId: aws.String("sg-1234567890abcdef0")}},
+		IamInstanceProfile: &ec2.IamInstanceProfile{Arn: aws.String("arn:aws:iam::123456789012:instance-profile/web")},
+		KeyName:            aws.String("web"),
+	}, nil)
+
+	tags := []*ec2.Tag{
+		{Key: aws.String("RestoredFromImageId"), Value: aws.String("ami-1234567890abcdef0")},
+		{Key: aws.String("RestoredFromInstanceId"), Value: aws.String("i-1234567890abcdef0")},
+		{Key: aws.String("Name"), Value: aws.String("web")},
+	}
+	mockAWSClient.EXPECT().RunInstance(context.TODO(), &ec2.RunInstancesInput{
+		ImageId:            aws.String("ami-1234567890abcdef0"),
+		InstanceType:       aws.String("t3.large"),
+		SubnetId:           aws.String("subnet-1234567890abcdef0"),
+		SecurityGroupIds:   []*string{aws.String("sg-1234567890abcdef0")},
+		IamInstanceProfile: &ec2.IamInstanceProfileSpecification{Arn: aws.String("arn:aws:iam::123456789012:instance-profile/web")},
+		KeyName:            aws.String("web"),
+		TagSpecifications: []*ec2.TagSpecification{
+			{ResourceType: aws.String("instance"), Tags: tags},
+			{ResourceType: aws.String("volume"), Tags: tags},
+		},
+	}).Return("i-1234567890abcdef1", nil)
+
+	restore := &Restore{
+		ImageID:  "ami-1234567890abcdef0",
+		Settings: InstanceSettings{InstanceType: "t3.large"},
+		Client:   mockAWSClient,
+	}
+	got, err := restore.Run(context.TODO(), image)
+	if err != nil {
+		t.Fatal("Run failed: ", err)
+	}
+	if got != "i-1234567890abcdef1" {
+		t.Fatalf("got %s, want i-1234567890abcdef1", got)
+	}
+}
+
+func TestRestoreRun_SourceInstanceNotFound(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	image := &ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		Tags:    []*ec2.Tag{{Key: aws.String("InstanceId"), Value: aws.String("i-1234567890abcdef0")}},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstance(context.TODO(), "i-1234567890abcdef0").Return(nil, nil)
+
+	restore := &Restore{ImageID: "ami-1234567890abcdef0", Client: mockAWSClient}
+	if _, err := restore.Run(context.TODO(), image); err == nil {
+		t.Fatal("Run should fail when instance type is unknown")
+	}
+}
+
+func TestInstanceSettingsMerge(t *testing.T) {
+	instance := &ec2.Instance{
+		InstanceType:   aws.String("t3.micro"),
+		SubnetId:       aws.String("subnet-1234567890abcdef0"),
+		SecurityGroups: []*ec2.GroupIdentifier{{GroupId: aws.String("sg-1234567890abcdef0")}, {GroupId: aws.String("sg-1234567890abcdef1")}},
+	}
+
+	settings := InstanceSettings{SubnetID: "subnet-1234567890abcdef1", KeyName: "ops"}
+	got := settings.merge(instance)
+	want := InstanceSettings{
+		InstanceType:     "t3.micro",
+		SubnetID:         "subnet-1234567890abcdef1",
+		SecurityGroupIDs: []string{"sg-1234567890abcdef0", "sg-1234567890abcdef1"},
+		KeyName:          "ops",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestVolumeRestoreRun(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	image := &ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		Tags:    []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef0"), VolumeType: aws.String("gp3")}},
+			{DeviceName: aws.String("/dev/sdf"), Ebs: &ec2.EbsBlockDevice{
+				SnapshotId: aws.String("snap-1234567890abcdef1"),
+				VolumeType: aws.String("gp3"),
+				Iops:       aws.Int64(4000),
+				Throughput: aws.Int64(250),
+			}},
+		},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstance(context.TODO(), "i-1234567890abcdef1").Return(&ec2.Instance{
+		InstanceId: aws.String("i-1234567890abcdef1"),
+		Placement:  &ec2.Placement{AvailabilityZone: aws.String("ap-northeast-1a")},
+	}, nil)
+	mockAWSClient.EXPECT().CreateVolume(context.TODO(), "snap-1234567890abcdef1", "ap-northeast-1a", "gp3", int64(4000), int64(250), []*ec2.Tag{
+		{Key: aws.String("RestoredFromImageId"), Value: aws.String("ami-1234567890abcdef0")},
+		{Key: aws.String("RestoredFromSnapshotId"), Value: aws.String("snap-1234567890abcdef1")},
+		{Key: aws.String("Name"), Value: aws.String("web")},
+	}).Return("vol-1234567890abcdef0", nil)
+	mockAWSClient.EXPECT().AttachVolume(context.TODO(), "vol-1234567890abcdef0", "i-1234567890abcdef1", "/dev/sdg").Return(nil)
+
+	restore := &VolumeRestore{
+		Device:       "/dev/sdf",
+		InstanceID:   "i-1234567890abcdef1",
+		AttachDevice: "/dev/sdg",
+		Client:       mockAWSClient,
+	}
+	got, err := restore.Run(context.TODO(), image)
+	if err != nil {
+		t.Fatal("Run failed: ", err)
+	}
+	if got != "vol-1234567890abcdef0" {
+		t.Fatalf("got %s, want vol-1234567890abcdef0", got)
+	}
+}
+
+func TestVolumeRestoreRun_Errors(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	image := &ec2.Image{
+		ImageId: aws.String("ami-1234567890abcdef0"),
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/sdf"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-1234567890abcdef1")}},
+		},
+	}
+
+	mockAWSClient := mock.NewMockAWS(mockCtrl)
+	mockAWSClient.EXPECT().GetInstance(context.TODO(), "i-1234567890abcdef1").Return(&ec2.Instance{
+		InstanceId: aws.String("i-1234567890abcdef1"),
+		Placement:  &ec2.Placement{AvailabilityZone: aws.String("ap-northeast-1c")},
+	}, nil)
+
+	var cases = []struct {
+		name    string
+		restore *VolumeRestore
+	}{
+		{
+			name:    "device not found",
+			restore: &VolumeRestore{Device: "/dev/sdg", AvailabilityZone: "ap-northeast-1a", Client: mockAWSClient},
+		},
+		{
+			name:    "availability zone mismatch",
+			restore: &VolumeRestore{Device: "/dev/sdf", AvailabilityZone: "ap-northeast-1a", InstanceID: "i-1234567890abcdef1", Client: mockAWSClient},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := c.restore.Run(context.TODO(), image); err == nil {
+				t.Fatal("Run should fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// RetentionPolicy keeps the newest machine image in each day, week, month and year,
+// like grandfather-father-son backup rotation. Periods are in UTC, and weeks start on Monday.
+type RetentionPolicy struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+func (p RetentionPolicy) isEmpty() bool {
+	return p.Daily == 0 && p.Weekly == 0 && p.Monthly == 0 && p.Yearly == 0
+}
+
+// keep returns ids of machine images to keep by the policy.
+// images must be sorted by creation date in ascending order, and failed images are never kept.
+func (p RetentionPolicy) keep(images []*ec2.Image) map[string]bool {
+	buckets := []struct {
+		count int
+		key   func(*ec2.Image) string
+	}{
+		{p.Daily, func(i *ec2.Image) string { return convertDate(*i.CreationDate).Format("2006-01-02") }},
+		{p.Weekly, func(i *ec2.Image) string {
+			year, week := convertDate(*i.CreationDate).ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+		{p.Monthly, func(i *ec2.Image) string { return convertDate(*i.CreationDate).Format("2006-01") }},
+		{p.Yearly, func(i *ec2.Image) string { return convertDate(*i.CreationDate).Format("2006") }},
+	}
+
+	keepImageIDs := make(map[string]bool)
+	for _, b := range buckets {
+		count := b.count
+		var last string
+		for i := len(images) - 1; i >= 0 && count > 0; i-- {
+			if *images[i].State == "failed" {
+				continue
+			}
+			if key := b.key(images[i]); key != last {
+				keepImageIDs[*images[i].ImageId] = true
+				last = key
+				count--
+			}
+		}
+	}
+
+	return keepImageIDs
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestRetentionPolicy_keep(t *testing.T) {
+	images := []*ec2.Image{
+		{ImageId: aws.String("ami-1234567890abcdef0"), CreationDate: aws.String("1970-01-01T00:00:00.000Z"), State: aws.String("failed")},
+		{ImageId: aws.String("ami-1234567890abcdef1"), CreationDate: aws.String("2019-12-15T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef2"), CreationDate: aws.String("2020-01-27T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef3"), CreationDate: aws.String("2020-02-03T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef4"), CreationDate: aws.String("2020-02-09T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef5"), CreationDate: aws.String("2020-02-10T04:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef6"), CreationDate: aws.String("2020-02-10T16:00:00.000Z"), State: aws.String("available")},
+		{ImageId: aws.String("ami-1234567890abcdef7"), CreationDate: aws.String("2020-02-11T04:00:00.000Z"), State: aws.String("available")},
+	}
+
+	var cases = []struct {
+		name   string
+		policy RetentionPolicy
+		want   map[string]bool
+	}{
+		{
+			name:   "daily",
+			policy: RetentionPolicy{Daily: 2},
+			want:   map[string]bool{"ami-1234567890abcdef6": true, "ami-1234567890abcdef7": true},
+		},
+		{
+			name:   "weekly",
+			policy: RetentionPolicy{Weekly: 2},
+			want:   map[string]bool{"ami-1234567890abcdef4": true, "ami-1234567890abcdef7": true},
+		},
+		{
+			name:   "monthly",
+			policy: RetentionPolicy{Monthly: 2},
+			want:   map[string]bool{"ami-1234567890abcdef2": true, "ami-1234567890abcdef7": true},
+		},
+		{
+			name:   "yearly",
+			policy: RetentionPolicy{Yearly: 3},
+			want:   map[string]bool{"ami-1234567890abcdef1": true, "ami-1234567890abcdef7": true},
+		},
+		{
+			name:   "all",
+			policy: RetentionPolicy{Daily: 2, Weekly: 2, Monthly: 2, Yearly: 2},
+			want: map[string]bool{
+				"ami-1234567890abcdef1": true,
+				"ami-1234567890abcdef2": true,
+				"ami-1234567890abcdef4": true,
+				"ami-1234567890abcdef6": true,
+				"ami-1234567890abcdef7": true,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.policy.keep(images)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
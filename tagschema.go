@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Keys of tags which are written by go-create-image-backup.
+// These are default keys, and can be renamed by TagSchema.
+const (
+	TagBackupType    = "BackupType"
+	TagName          = "Name"
+	TagService       = "Service"
+	TagInstanceID    = "InstanceId"
+	TagSourceImageID = "SourceImageId"
+	TagBackupSetID   = "BackupSetId"
+	TagBackupTier    = "BackupTier"
+	TagDeviceName    = "DeviceName"
+	TagVolumeID      = "VolumeId"
+	TagVolumeType    = "VolumeType"
+	TagVolumeSize    = "VolumeSize"
+	TagImageID       = "ImageId"
+
+	TagRestoredFromImageID    = "RestoredFromImageId"
+	TagRestoredFromInstanceID = "RestoredFromInstanceId"
+	TagRestoredFromSnapshotID = "RestoredFromSnapshotId"
+)
+
+// tagKeys are all keys of tags which are written by go-create-image-backup.
+var tagKeys = []string{
+	TagBackupType,
+	TagName,
+	TagService,
+	TagInstanceID,
+	TagSourceImageID,
+	TagBackupSetID,
+	TagBackupTier,
+	TagDeviceName,
+	TagVolumeID,
+	TagVolumeType,
+	TagVolumeSize,
+	TagImageID,
+	TagRestoredFromImageID,
+	TagRestoredFromInstanceID,
+	TagRestoredFromSnapshotID,
+}
+
+// DefaultBackupTypeValue is the value of BackupType tag by default.
+const DefaultBackupTypeValue = "auto"
+
+// TagSchema is keys and values of tags which are written by go-create-image-backup,
+// so that these do not collide with tags of other tools. The zero value is the default schema.
+type TagSchema struct {
+	// Prefix is prepended to all keys like "backup:".
+	Prefix string
+	// Keys renames default keys like Service to BackupService.
+	Keys map[string]string
+	// BackupTypeValue is the value of BackupType tag, empty means DefaultBackupTypeValue.
+	BackupTypeValue string
+}
+
+// Key returns the key of tag in the schema for the default key.
+func (s *TagSchema) Key(key string) string {
+	if k, ok := s.Keys[key]; ok {
+		key = k
+	}
+	return s.Prefix + key
+}
+
+// Tag returns the tag which has the key in the schema for the default key.
+func (s *TagSchema) Tag(key, value string) *ec2.Tag {
+	return &ec2.Tag{
+		Key:   aws.String(s.Key(key)),
+		Value: aws.String(value),
+	}
+}
+
+// BackupType returns the value of BackupType tag.
+func (s *TagSchema) BackupType() string {
+	if s.BackupTypeValue == "" {
+		return DefaultBackupTypeValue
+	}
+	return s.BackupTypeValue
+}
+
+// defaultKey returns the default key for the key in the schema.
+func (s *TagSchema) defaultKey(key string) (string, bool) {
+	for _, k := range tagKeys {
+		if s.Key(k) == key {
+			return k, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestTagSchemaKey(t *testing.T) {
+	var cases = []struct {
+		schema TagSchema
+		key    string
+		want   string
+	}{
+		{schema: TagSchema{}, key: TagService, want: "Service"},
+		{schema: TagSchema{Prefix: "backup:"}, key: TagService, want: "backup:Service"},
+		{schema: TagSchema{Prefix: "backup:", Keys: map[string]string{TagService: "BackupService"}}, key: TagService, want: "backup:BackupService"},
+		{schema: TagSchema{Keys: map[string]string{TagService: "BackupService"}}, key: TagName, want: "Name"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.want, func(t *testing.T) {
+			if got := c.schema.Key(c.key); got != c.want {
+				t.Fatalf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTagSchemaBackupType(t *testing.T) {
+	s := TagSchema{}
+	if got := s.BackupType(); got != DefaultBackupTypeValue {
+		t.Fatalf("got %s, want %s", got, DefaultBackupTypeValue)
+	}
+
+	s = TagSchema{BackupTypeValue: "go-create-image-backup"}
+	if got := s.BackupType(); got != "go-create-image-backup" {
+		t.Fatalf("got %s, want %s", got, "go-create-image-backup")
+	}
+}
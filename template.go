@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+const (
+	// DefaultNameTemplate is the template of machine image name by default.
+	DefaultNameTemplate = `{{.Name}}-{{.Time.Format "200601021504"}}`
+	// DefaultDescriptionTemplate is the template of machine image description by default.
+	DefaultDescriptionTemplate = "create by go-create-image-backup"
+)
+
+// maxSequence is the maximum sequence number to find a machine image name which does not exist.
+const maxSequence = 100
+
+// maxImageNameLength is the maximum length of machine image name.
+const maxImageNameLength = 128
+
+// imageNamePattern is the naming rule of machine image.
+var imageNamePattern = regexp.MustCompile(`^[a-zA-Z0-9()\[\] ./\-'@_]{3,128}$`)
+
+// TemplateData is the data to render templates of machine image name and description.
+type TemplateData struct {
+	// InstanceID is the instance id of backup source.
+	InstanceID string
+	// Name is the value of Name tag, or the instance id when the value has non-ASCII characters.
+	Name string
+	// Service is the value of Service tag.
+	Service string
+	// Time is the time of backup in local time.
+	Time time.Time
+	// UTC is the time of backup in UTC.
+	UTC time.Time
+	// Sequence starts with 1 and is incremented while the machine image name already exists.
+	Sequence int
+	// Tags are the custom tags.
+	Tags map[string]string
+}
+
+// ParseTemplate parses the template of machine image name or description.
+// Unknown fields and keys of custom tags are errors on rendering.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+func renderTemplate(t *template.Template, data TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func validateImageName(name string) error {
+	if !imageNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid image name: %q, it must be 3-128 alphanumeric characters, parentheses, square brackets, spaces, periods, slashes, dashes, single quotes, at-signs or underscores", name)
+	}
+	return nil
+}
+
+func validateImageDescription(description string) error {
+	if len(description) > 255 {
+		return fmt.Errorf("invalid image description: %q, it must be up to 255 characters", description)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	data := TemplateData{
+		InstanceID: "i-1234567890abcdef0",
+		Name:       "test",
+		Service:    "service",
+		UTC:        time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+		Sequence:   1,
+		Tags:       map[string]string{"Env": "prod"},
+	}
+
+	var cases = []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{text: `{{.Name}}-{{.UTC.Format "20060102T150405Z"}}`, want: "test-20060102T150405Z"},
+		{text: `{{.Service}}/{{.Tags.Env}}/{{.InstanceID}}-{{.Sequence}}`, want: "service/prod/i-1234567890abcdef0-1"},
+		{text: `{{.Tags.Role}}`, wantErr: true},
+		{text: `{{.Unknown}}`, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			tmpl, err := ParseTemplate("name", c.text)
+			if err != nil {
+				t.Fatal("ParseTemplate failed: ", err)
+			}
+			got, err := renderTemplate(tmpl, data)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error %t", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Fatalf("got %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestValidateImageName(t *testing.T) {
+	var cases = []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "test-200601021504"},
+		{name: "test (daily) [1] ./-'@_"},
+		{name: "te", wantErr: true},
+		{name: strings.Repeat("a", 129), wantErr: true},
+		{name: "test:200601021504", wantErr: true},
+		{name: "テスト-200601021504", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := validateImageName(c.name); (err != nil) != c.wantErr {
+				t.Fatalf("got error %v, want error %t", err, c.wantErr)
+			}
+		})
+	}
+}